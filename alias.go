@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// shellQuote quotes s for sh, as git runs "!" aliases with the shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// runInstallAlias sets up git aliases running this binary, leaving alone
+// any existing alias of the same name unless forced.
+func runInstallAlias(args []string) {
+	flags := flag.NewFlagSet("install-alias", flag.ExitOnError)
+	var (
+		local = flags.Bool("local", false, "Write to the repository's config instead of the global one")
+		force = flags.Bool("force", false, "Replace existing aliases")
+	)
+	flags.Parse(args)
+
+	executable, err := os.Executable()
+	dieIf(err)
+
+	aliases := []struct {
+		name string
+		args string
+	}{
+		{"ci-status", "-verbose -progress"},
+		{"ci-watch", "-watch -progress"},
+	}
+
+	scope := "--global"
+	if *local {
+		scope = "--local"
+	}
+
+	for _, a := range aliases {
+		key := "alias." + a.name
+		value := fmt.Sprintf("!%s %s", shellQuote(executable), a.args)
+
+		existing := statusmark.GitConfig(scope, "--get", key)
+		switch {
+		case existing == value:
+			fmt.Printf("git %s: already installed\n", a.name)
+			continue
+		case existing != "" && !*force:
+			fmt.Fprintf(os.Stderr, "git %s: kept existing alias %q (use -force to replace)\n", a.name, existing)
+			continue
+		}
+
+		_, err := statusmark.RunGit("config", scope, key, value)
+		dieIf(err)
+		fmt.Printf("git %s: installed\n", a.name)
+	}
+}
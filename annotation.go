@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+type checkAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	StartColumn     int    `json:"start_column"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title"`
+	Message         string `json:"message"`
+}
+
+func listAnnotations(client *github.Client, remote statusmark.Remote, id int64) ([]checkAnnotation, error) {
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/check-runs/%d/annotations?per_page=100", remote.Owner, remote.Name, id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var annotations []checkAnnotation
+	_, err = client.Do(req, &annotations)
+	return annotations, err
+}
+
+// quickfixLine formats a as file:line:col: message, which Vim's default
+// errorformat understands. The message is folded into a single line.
+func quickfixLine(run statusmark.CheckRun, a checkAnnotation, path string) string {
+	col := a.StartColumn
+	if col == 0 {
+		col = 1
+	}
+
+	message := strings.Join(strings.Fields(a.Message), " ")
+	if a.Title != "" {
+		message = a.Title + ": " + message
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s: [%s] %s", path, a.StartLine, col, a.AnnotationLevel, run.Name, message)
+}
+
+// runAnnotations prints the annotations of the failing check runs of the
+// target revision for Vim's quickfix list, as in
+// :cexpr system('github-commit-status-mark annotations').
+func runAnnotations(repo statusmark.Repository, args []string, dryRun bool) {
+	flags := flag.NewFlagSet("annotations", flag.ExitOnError)
+	all := flags.Bool("all", false, "Include annotations of check runs that did not fail")
+	onlyDiff := flags.Bool("diff", false, "Only show annotations on lines changed since the merge-base with the default branch")
+	base := flags.String("base", "", "Compare with `ref` instead of the default branch for -diff")
+	flags.Parse(args)
+
+	toplevel, rev, err := repo.Resolve(targetRevision(flags.Args()))
+	dieIf(err)
+
+	var changed changedLines
+	if *onlyDiff {
+		if *base == "" {
+			*base, err = defaultBranch(statusmark.ConfiguredRemotes(repo)[0])
+			dieIf(err)
+		}
+		changed, err = diffLines(*base, rev)
+		dieIf(err)
+	}
+
+	state, err := statusmark.NewCache(repo)
+	dieIf(err)
+	dieIf(state.Restore())
+
+	remote, err := statusmark.ParseRemote(repo, statusmark.ConfiguredRemotes(repo)[0])
+	dieIf(err)
+	requireGitHub(remote, "annotations")
+	// Counted apart, to be added to the cache read again under its lock
+	var apiCalls int64
+	client := statusmark.NewAPIClient(remote.URL, statusmark.ClientOptions{
+		RetryPolicy: statusmark.LoadRetryPolicy(statusmark.RetryModePrompt),
+		APICalls:    &apiCalls,
+		DryRun:      dryRun,
+	})
+	dieIf(statusmark.RequireFeature(state.HostInfo(client, remote), remote, statusmark.FeatureChecks))
+
+	runs, err := statusmark.ListCheckRuns(client, remote, rev)
+	if err != nil {
+		die(fmt.Sprintf("Error while fetching check runs: %s", err))
+	}
+
+	// Annotation paths are relative to the toplevel, the quickfix list's
+	// to where Vim runs us
+	cwd, err := os.Getwd()
+	dieIf(err)
+
+	conclusions := statusmark.ConclusionStates()
+	for _, run := range runs {
+		if run.Output.AnnotationsCount == 0 {
+			continue
+		}
+		if !*all && !statusmark.IsFailing(statusmark.CheckRunState(run.Status, run.Conclusion, conclusions)) {
+			continue
+		}
+
+		annotations, err := listAnnotations(client, remote, run.ID)
+		if err != nil {
+			die(fmt.Sprintf("Error while fetching annotations of %s: %s", run.Name, err))
+		}
+
+		for _, a := range annotations {
+			if changed != nil && !changed.overlaps(a.Path, a.StartLine, a.EndLine) {
+				continue
+			}
+
+			path, err := filepath.Rel(cwd, filepath.Join(toplevel, a.Path))
+			if err != nil {
+				path = a.Path
+			}
+			fmt.Println(quickfixLine(run, a, path))
+		}
+	}
+
+	dieIf(state.Update(context.Background(), func() error {
+		state.Stats.APICalls += apiCalls
+		return nil
+	}))
+}
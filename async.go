@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// refreshInBackground starts this program again to fetch the status of rev
+// into the cache of toplevel, or of remoteRepo given with -repo, without
+// waiting for it, so that the next run finds it fresh.
+func refreshInBackground(toplevel, remoteRepo, rev string, upstream, associatedPR, graphQL bool) error {
+	// Without stdout and stderr, it does not hold up a prompt reading the
+	// output of this process
+	cmd, err := refreshCommand(toplevel, remoteRepo, rev, upstream, associatedPR, graphQL)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// refreshCommand returns the command running this program again to fetch
+// the status of rev into the cache of toplevel, or of remoteRepo given
+// with -repo. The settings affecting what is cached are passed along.
+func refreshCommand(toplevel, remoteRepo, rev string, upstream, associatedPR, graphQL bool) (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-C", toplevel, "-update"}
+	if remoteRepo != "" {
+		args = []string{"-repo", remoteRepo, "-update"}
+	}
+	if statusmark.RemoteName != "" {
+		args = append(args, "-remote", statusmark.RemoteName)
+	}
+	if statusmark.Profile != "" {
+		args = append(args, "-profile", statusmark.Profile)
+	}
+	if upstream {
+		args = append(args, "-upstream")
+	}
+	if associatedPR {
+		args = append(args, "-associated-pr")
+	}
+	if graphQL {
+		args = append(args, "-graphql")
+	}
+	if statusmark.CAFile != "" {
+		caFile, err := filepath.Abs(statusmark.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-ca-file", caFile)
+	}
+	if statusmark.APIBase != "" {
+		args = append(args, "-api-base", statusmark.APIBase)
+	}
+	if statusmark.Insecure {
+		args = append(args, "-insecure")
+	}
+	if statusmark.CacheDir != "" {
+		args = append(args, "-cache-dir", statusmark.CacheDir)
+	}
+	args = append(args, rev)
+
+	return exec.Command(executable, args...), nil
+}
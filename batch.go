@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// batchLine is a revision to look up among many and what became of it.
+type batchLine struct {
+	rev   string
+	sha   string
+	entry statusmark.Entry
+	err   error
+	// text follows the mark in annotate-log
+	text string
+}
+
+// fetchBatch looks up the entries of lines which have their commit
+// resolved. Commits not fresh in the cache are fetched by concurrency
+// workers at once, each with a fork of the cache merged back at the end;
+// the clients share their connections.
+func fetchBatch(lookup *statusmark.Lookup, lines []*batchLine, concurrency int) {
+	// The same commit is fetched only once
+	var pending []string
+	bySHA := map[string][]*batchLine{}
+	for _, line := range lines {
+		if line.err != nil {
+			continue
+		}
+
+		if _, ok := bySHA[line.sha]; !ok {
+			if entry, fresh := lookup.Cached(line.sha); fresh {
+				lookup.Cache.RecordHit()
+				line.entry = entry
+			} else {
+				pending = append(pending, line.sha)
+			}
+		}
+		bySHA[line.sha] = append(bySHA[line.sha], line)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	shas := make(chan string)
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			worker := *lookup
+			worker.Cache = lookup.Cache.Fork()
+			for sha := range shas {
+				worker.Cache.Stats.Misses++
+				entry, _, _, err := worker.Fetch(sha)
+
+				mu.Lock()
+				for _, line := range bySHA[sha] {
+					line.entry, line.err = entry, err
+				}
+				mu.Unlock()
+			}
+
+			mu.Lock()
+			lookup.Cache.Merge(worker.Cache)
+			mu.Unlock()
+		}()
+	}
+	for _, sha := range pending {
+		shas <- sha
+	}
+	close(shas)
+	wg.Wait()
+
+	for _, line := range lines {
+		if line.err == nil {
+			line.entry = lookup.Selected(bySHA[line.sha][0].entry)
+		}
+	}
+}
+
+// runBatch prints "<sha> <mark>" for every revision read from in, one per
+// line.
+func runBatch(lookup *statusmark.Lookup, p *preset, in io.Reader, concurrency int) {
+	var lines []*batchLine
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		rev := strings.TrimSpace(scanner.Text())
+		if rev == "" {
+			continue
+		}
+
+		line := &batchLine{rev: rev}
+		_, line.sha, line.err = lookup.Repo.Resolve(rev)
+		lines = append(lines, line)
+	}
+	dieIf(scanner.Err())
+
+	fetchBatch(lookup, lines, concurrency)
+
+	for _, line := range lines {
+		if line.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", line.rev, line.err)
+			fmt.Print(line.rev + " ")
+			printMark(p, statusmark.StatusUnknown, "")
+			fmt.Println()
+			continue
+		}
+
+		fmt.Print(line.sha + " ")
+		printMark(p, line.entry.Status, "")
+		fmt.Println()
+	}
+}
+
+// runAnnotateLog prints git log --oneline with args, each commit prefixed
+// with its mark. Only the last github-commit-status.logCount commits (20
+// by default) are shown unless args say otherwise, as every one may take
+// an API call.
+func runAnnotateLog(lookup *statusmark.Lookup, p *preset, args []string, concurrency int) {
+	gitArgs := append([]string{"log", "--no-color", "--format=%H %h%d %s", "-n", strconv.Itoa(statusmark.ConfigInt("logCount", 20))}, args...)
+	out, err := statusmark.RunGit(gitArgs...)
+	dieIf(err)
+
+	var lines []*batchLine
+	for _, l := range strings.Split(out, "\n") {
+		fields := strings.SplitN(l, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		lines = append(lines, &batchLine{rev: fields[0], sha: fields[0], text: fields[1]})
+	}
+
+	fetchBatch(lookup, lines, concurrency)
+
+	for _, line := range lines {
+		if line.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", line.rev, line.err)
+		}
+		printMark(p, line.entry.Status, "")
+		fmt.Println(" " + line.text)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+type commitPerson struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type commitAccount struct {
+	Login string `json:"login"`
+}
+
+type commitDetail struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Author    commitPerson `json:"author"`
+		Committer commitPerson `json:"committer"`
+		Message   string       `json:"message"`
+	} `json:"commit"`
+	Author    *commitAccount `json:"author"`
+	Committer *commitAccount `json:"committer"`
+}
+
+type pushActivity struct {
+	After string         `json:"after"`
+	Actor *commitAccount `json:"actor"`
+}
+
+func fetchCommitDetail(client *github.Client, remote statusmark.Remote, rev string) (*commitDetail, error) {
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/commits/%s", remote.Owner, remote.Name, rev), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var detail commitDetail
+	_, err = client.Do(req, &detail)
+	return &detail, err
+}
+
+// fetchPusher looks up who pushed rev using the repository activity API,
+// which is not available on every host; an empty string means unknown.
+func fetchPusher(client *github.Client, remote statusmark.Remote, rev string) string {
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/activity?activity_type=push&per_page=100", remote.Owner, remote.Name), nil)
+	if err != nil {
+		return ""
+	}
+
+	var activities []pushActivity
+	if _, err := client.Do(req, &activities); err != nil {
+		return ""
+	}
+
+	for _, a := range activities {
+		if a.After == rev && a.Actor != nil {
+			return a.Actor.Login
+		}
+	}
+
+	return ""
+}
+
+func formatPerson(p commitPerson, account *commitAccount) string {
+	s := fmt.Sprintf("%s <%s>", p.Name, p.Email)
+	if account != nil && account.Login != "" {
+		s += fmt.Sprintf(" (@%s)", account.Login)
+	}
+	return s
+}
+
+// shortSHA abbreviates sha as git does by default. A revision given with
+// -sha may be a branch name or an abbreviation already, so it is only cut
+// when longer.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func printBlame(client *github.Client, remote statusmark.Remote, rev string, l layout) error {
+	detail, err := fetchCommitDetail(client, remote, rev)
+	if err != nil {
+		return fmt.Errorf("Error while fetching commit: %s", err)
+	}
+
+	subject := strings.SplitN(detail.Commit.Message, "\n", 2)[0]
+	if detail.SHA != "" {
+		// rev need not be a full SHA, as with -repo and -sha
+		rev = detail.SHA
+	}
+
+	fmt.Println()
+	l.println("commit:    %s %s", shortSHA(rev), subject)
+	l.println("author:    %s", formatPerson(detail.Commit.Author, detail.Author))
+	l.println("committer: %s", formatPerson(detail.Commit.Committer, detail.Committer))
+	if pusher := fetchPusher(client, remote, rev); pusher != "" {
+		l.println("pushed by: @%s", pusher)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// runCache inspects or maintains the cache of repo: "show" lists the
+// entries, most recently fetched first; "clear" removes those of the
+// revisions given (as after a force-push), or all; "path" prints where it
+// is kept; "stats" prints its statistics; and "gc" prunes old entries.
+// Changes are saved under the lock of the cache.
+func runCache(repo statusmark.Repository, args []string) {
+	state, err := statusmark.NewCache(repo)
+	dieIf(err)
+	dieIf(state.Restore())
+
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "show":
+		keys := make([]string, 0, len(state.Revisions))
+		for key := range state.Revisions {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return state.Revisions[keys[i]].LastModified > state.Revisions[keys[j]].LastModified
+		})
+
+		for _, key := range keys {
+			entry := state.Revisions[key]
+			age := time.Since(time.Unix(entry.LastModified, 0)).Round(time.Second)
+			fmt.Printf("%s %-15s %10s ago  %s\n", key, entry.Status, age, entry.Rule)
+		}
+
+	case "clear":
+		var shas []string
+		for _, rev := range args[1:] {
+			_, sha, err := repo.Resolve(rev)
+			dieIf(err)
+			shas = append(shas, sha)
+		}
+
+		removed := 0
+		dieIf(state.Update(context.Background(), func() error {
+			if len(args) == 1 {
+				removed = len(state.Revisions)
+				state.Revisions = nil
+			}
+			for _, sha := range shas {
+				for key := range state.Revisions {
+					// Pull requests are cached by "pull/<number>/<sha>", and
+					// the statuses of upstreams by "upstream/<sha>"
+					if key == sha || strings.HasSuffix(key, "/"+sha) {
+						delete(state.Revisions, key)
+						removed++
+					}
+				}
+			}
+			return nil
+		}))
+		fmt.Printf("Cleared %d entries\n", removed)
+
+	case "path":
+		fmt.Println(state.Path())
+
+	case "stats":
+		state.Stats.Print()
+
+	case "gc":
+		removed := 0
+		dieIf(state.Update(context.Background(), func() error {
+			removed = state.Prune()
+			return nil
+		}))
+		fmt.Printf("Removed %d entries, %d left\n", removed, len(state.Revisions))
+
+	default:
+		die("usage: github-commit-status-mark cache show|clear [<rev>...]|path|stats|gc")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/daviddengcn/go-colortext"
+	"github.com/motemen/github-commit-status-mark/statusmark"
+	"golang.org/x/term"
+)
+
+const (
+	colorNever  = "never"
+	colorAlways = "always"
+	colorAuto   = "auto"
+)
+
+// colorFlag is -color, if given.
+var colorFlag string
+
+// colorUISetting, if set, is the color.ui setting as told by the daemon.
+var colorUISetting string
+
+// colorUI returns when to color as one of colorNever, colorAlways or
+// colorAuto: as -color says, never if NO_COLOR is set, or else as
+// github-commit-status.color or git's color.ui says, as the marks mostly
+// end up next to git's own output.
+func colorUI() string {
+	if colorFlag != "" {
+		return colorFlag
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return colorNever
+	}
+	if colorUISetting != "" {
+		return colorUISetting
+	}
+
+	setting := statusmark.ConfigValue("color")
+	if setting == "" {
+		setting = statusmark.GitConfig("--get", "color.ui")
+	}
+	switch strings.ToLower(setting) {
+	case "never", "false":
+		return colorNever
+	case "always", "true":
+		return colorAlways
+	}
+
+	return colorAuto
+}
+
+// terminalColor reports whether marks printed to the terminal are colored:
+// always or never if colorUI says so, and otherwise only when the output is
+// a terminal, not a dumb or unknown one. Prompts capture the output, so
+// they need -color always or a preset.
+func terminalColor() bool {
+	switch colorUI() {
+	case colorNever:
+		return false
+	case colorAlways:
+		return true
+	}
+
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return false
+	}
+
+	// The Windows console is colored through API calls, without TERM
+	if runtime.GOOS == "windows" {
+		return true
+	}
+
+	term := os.Getenv("TERM")
+	return term != "" && term != "dumb"
+}
+
+var colorNames = map[string]ct.Color{
+	"none":    ct.None,
+	"black":   ct.Black,
+	"red":     ct.Red,
+	"green":   ct.Green,
+	"yellow":  ct.Yellow,
+	"blue":    ct.Blue,
+	"magenta": ct.Magenta,
+	"cyan":    ct.Cyan,
+	"white":   ct.White,
+}
+
+// useStatusSettings replaces the marks and colors in statusConfiguration
+// with those set by <status>.mark and <status>.color. Colors are the eight
+// ANSI ones, which the palette of the terminal decides the look of.
+func useStatusSettings(settings map[string]statusmark.StatusSetting) error {
+	for status, s := range settings {
+		conf := statusConfiguration[status]
+		if s.Mark != "" {
+			conf.mark = s.Mark
+		}
+		if s.Color != "" {
+			color, ok := colorNames[strings.ToLower(s.Color)]
+			if !ok {
+				return fmt.Errorf("%s.color: no such color: %s", statusmark.StatusName(status), s.Color)
+			}
+			conf.color = color
+		}
+		statusConfiguration[status] = conf
+	}
+
+	return nil
+}
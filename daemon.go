@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// The daemon answers queries over a Unix domain socket, one per connection:
+// the client sends the absolute directory and the revision separated by a
+// tab, and the daemon replies with the status, the numbers of completed and
+// total contexts, its color.ui setting, and the mark and color set for the
+// status if any, separated by tabs. Both are single lines, so that the
+// socket can be queried by other tools too, e.g.
+//
+//	printf '%s\tHEAD\n' "$PWD" | nc -U "$socket"
+//
+// A query of "health" alone is answered with "ok", the number of
+// repositories kept and that of fetches queued or in progress, once the
+// daemon is free to answer queries.
+//
+// On SIGHUP, the daemon reads its settings again, keeping the statuses it
+// has in memory.
+
+// defaultSocketPath returns where the daemon listens unless told otherwise:
+// in $XDG_RUNTIME_DIR, or the temporary directory with the user id in the
+// name.
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "github-commit-status-mark.sock")
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("github-commit-status-mark-%d.sock", os.Getuid()))
+}
+
+// daemonReply is the answer of the daemon to a query.
+type daemonReply struct {
+	status           string
+	completed, total int
+	colorUI          string
+	setting          statusmark.StatusSetting
+}
+
+// queryDaemon asks the daemon at socket for the status of rev in dir,
+// giving up after timeout.
+func queryDaemon(socket, dir, rev string, timeout time.Duration) (daemonReply, error) {
+	conn, err := net.DialTimeout("unix", socket, 100*time.Millisecond)
+	if err != nil {
+		return daemonReply{}, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := fmt.Fprintf(conn, "%s\t%s\n", dir, rev); err != nil {
+		return daemonReply{}, err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return daemonReply{}, err
+	}
+
+	fields := strings.Split(strings.TrimRight(line, "\n"), "\t")
+	if len(fields) == 1 && strings.HasPrefix(fields[0], "error: ") {
+		return daemonReply{}, fmt.Errorf("daemon: %s", strings.TrimPrefix(fields[0], "error: "))
+	}
+	if len(fields) != 6 {
+		return daemonReply{}, fmt.Errorf("daemon: malformed reply %q", line)
+	}
+
+	reply := daemonReply{
+		status:  fields[0],
+		colorUI: fields[3],
+		setting: statusmark.StatusSetting{Mark: fields[4], Color: fields[5]},
+	}
+	reply.completed, _ = strconv.Atoi(fields[1])
+	reply.total, _ = strconv.Atoi(fields[2])
+	return reply, nil
+}
+
+// daemonFetchTimeout is how long the daemon waits for the API in a fetch.
+const daemonFetchTimeout = 30 * time.Second
+
+// daemonRepo is a repository the daemon has been asked about. Its lookup,
+// whose cache is kept in memory, is only used by the worker of the daemon;
+// queries are answered from the entries it publishes.
+type daemonRepo struct {
+	toplevel string
+	// lookup is nil until the worker has loaded the repository, and err
+	// why it could not
+	lookup *statusmark.Lookup
+	err    error
+	// revs are the revisions asked for, refreshed in the background
+	revs map[string]bool
+	// entries are those of the commits asked about, and settings those of
+	// the statuses, as last published
+	entries  map[string]daemonEntry
+	settings map[string]statusmark.StatusSetting
+	// queued are the commits queued to be fetched or being fetched
+	queued map[string]bool
+	// hits are the queries answered from entries, not yet counted in the
+	// cache
+	hits int
+	// gitDir and commonDir are watched for changes, last seen as stamp
+	gitDir, commonDir, stamp string
+}
+
+// daemonEntry is an entry of the cache and whether it was fresh when
+// published.
+type daemonEntry struct {
+	entry statusmark.Entry
+	fresh bool
+}
+
+// daemonFetch is a commit of a repository queued to be fetched; ctx only
+// carries the span to trace the fetch under.
+type daemonFetch struct {
+	r   *daemonRepo
+	sha string
+	ctx context.Context
+}
+
+type daemon struct {
+	// mu guards the repositories as published and the settings of the
+	// daemon. Everything that uses the library, which looks up git and its
+	// settings in the working directory, is done by the worker, one thing
+	// at a time and without mu held, so that a slow fetch holds up no
+	// query.
+	mu      sync.Mutex
+	repos   map[string]*daemonRepo
+	colorUI string
+	// fetches are queued for the worker
+	fetches chan daemonFetch
+	// dir is where the daemon was started, whose settings are its own
+	dir string
+}
+
+// status answers a query from the published entries at once, as unknown if
+// there is no entry yet; missing and expired entries are queued to be
+// fetched. dir must be absolute, as the working directory of the daemon is
+// that of the worker.
+func (d *daemon) status(ctx context.Context, dir, rev string) (entry statusmark.Entry, setting statusmark.StatusSetting, err error) {
+	if !filepath.IsAbs(dir) {
+		return entry, setting, fmt.Errorf("not an absolute path: %s", dir)
+	}
+
+	repo := statusmark.OpenRepositoryAt(dir)
+	toplevel, sha, err := repo.Resolve(rev)
+	if err != nil {
+		return entry, setting, err
+	}
+	pushed := repo.IsPushed(sha)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r, ok := d.repos[toplevel]
+	if !ok {
+		r = &daemonRepo{
+			toplevel: toplevel,
+			revs:     map[string]bool{},
+			entries:  map[string]daemonEntry{},
+			queued:   map[string]bool{},
+		}
+		d.repos[toplevel] = r
+	}
+	if r.err != nil {
+		return entry, setting, r.err
+	}
+	r.revs[rev] = true
+
+	// Unpushed commits are never cached, as nothing is asked about them
+	if !pushed {
+		entry = statusmark.Entry{Status: statusmark.StatusLocal}
+		return entry, r.settings[entry.Status], nil
+	}
+
+	cached := r.entries[sha]
+	if cached.fresh {
+		r.hits++
+	} else {
+		d.queue(ctx, r, sha)
+	}
+
+	return cached.entry, r.settings[cached.entry.Status], nil
+}
+
+// queue has the worker fetch sha in r, unless it already is to. Call with
+// d.mu held.
+func (d *daemon) queue(ctx context.Context, r *daemonRepo, sha string) {
+	if r.queued[sha] {
+		return
+	}
+
+	select {
+	case d.fetches <- daemonFetch{r: r, sha: sha, ctx: ctx}:
+		r.queued[sha] = true
+	default:
+		// The worker is far behind; the next query or refresh asks again
+	}
+}
+
+// work runs the worker until the daemon exits: it fetches what queries
+// queue, refreshes the repositories every interval and those whose git
+// directories have changed as soon as it sees, and reads the settings
+// again on hup.
+func (d *daemon) work(interval time.Duration, hup <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	watchTicker := time.NewTicker(daemonWatchInterval())
+
+	for {
+		select {
+		case f := <-d.fetches:
+			d.fetch(f)
+
+		case <-ticker.C:
+			d.refresh()
+
+		case <-watchTicker.C:
+			d.watch()
+
+		case <-hup:
+			interval, watchInterval := d.reload()
+			ticker.Reset(interval)
+			watchTicker.Reset(watchInterval)
+		}
+	}
+}
+
+// enter has the library look at the repository of r, loading it the first
+// time, and publishes its settings. Call from the worker.
+func (d *daemon) enter(r *daemonRepo) error {
+	err := os.Chdir(r.toplevel)
+	if err == nil {
+		err = statusmark.LoadRepoConfig(r.toplevel)
+	}
+	if err == nil && r.lookup == nil {
+		err = d.load(r)
+	}
+	if err == nil {
+		r.lookup.Upstream = statusmark.ConfigBool("upstream")
+		r.lookup.GraphQL = statusmark.ConfigBool("graphql")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r.err = err
+	if err == nil {
+		r.settings = statusmark.StatusSettings()
+	}
+
+	return err
+}
+
+// load opens the repository in the working directory for r, restoring its
+// cache. The connection to the API is made at once, so that the first
+// fetch does not wait to resolve the host and shake hands; it is kept open
+// in the transport shared by every fetch from the same remote.
+func (d *daemon) load(r *daemonRepo) error {
+	repo := statusmark.OpenRepository()
+
+	state, err := statusmark.NewCache(repo)
+	if err != nil {
+		return err
+	}
+	if err := state.Restore(); err != nil {
+		return err
+	}
+
+	commonDir, err := repo.CommonDir()
+	if err != nil {
+		return err
+	}
+
+	r.lookup = &statusmark.Lookup{
+		Repo:      repo,
+		Cache:     state,
+		RetryMode: statusmark.RetryModeWatch,
+	}
+	r.gitDir, r.commonDir = gitDirOf(r.toplevel), commonDir
+	r.stamp = gitStamp(r.gitDir, r.commonDir)
+
+	if remote, err := statusmark.ParseRemote(repo, statusmark.ConfiguredRemotes(repo)[0]); err == nil {
+		if root, err := statusmark.APIRoot(remote.URL); err == nil {
+			statusmark.CheckConnection(remote.URL, root, daemonFetchTimeout)
+		}
+	}
+
+	return nil
+}
+
+// fetch fetches the commit f asks for, unless its entry has turned fresh
+// meanwhile. Call from the worker.
+func (d *daemon) fetch(f daemonFetch) {
+	ctx, end := startSpan(f.ctx, "daemon.fetch", "repository", f.r.toplevel, "sha", f.sha)
+
+	err := d.enter(f.r)
+	if err == nil {
+		err = d.fetchStale(ctx, f.r, []string{f.sha})
+	}
+
+	d.mu.Lock()
+	delete(f.r.queued, f.sha)
+	d.mu.Unlock()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: fetching %s: %s\n", f.r.toplevel, f.sha, err)
+	}
+	end(err)
+}
+
+// fetchStale has those of shas whose entries have expired fetched, under
+// the lock of the cache, which is saved with the hits since the last time,
+// and publishes the entries of r. How long fetches take is in 'cache
+// stats'. Call from the worker with r entered.
+func (d *daemon) fetchStale(ctx context.Context, r *daemonRepo, shas []string) error {
+	l := r.lookup
+
+	var stale []string
+	for _, sha := range shas {
+		if _, fresh := l.Cached(sha); !fresh {
+			stale = append(stale, sha)
+		}
+	}
+
+	d.mu.Lock()
+	hits := r.hits
+	r.hits = 0
+	d.mu.Unlock()
+
+	var err error
+	if len(stale) > 0 || hits > 0 {
+		ctx, cancel := context.WithTimeout(ctx, daemonFetchTimeout)
+		defer cancel()
+		l.Context = ctx
+
+		for i := 0; i < hits; i++ {
+			l.Cache.RecordHit()
+		}
+
+		err = l.Cache.Update(ctx, func() error {
+			// Another process may have fetched them while the lock was taken
+			for _, sha := range stale {
+				if _, fresh := l.Cached(sha); fresh {
+					continue
+				}
+				l.Cache.Stats.Misses++
+				if _, _, _, err := l.Fetch(sha); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	d.publish(r, shas)
+	return err
+}
+
+// publish has queries answered with the entries of shas and of the commits
+// asked about before in r. Call from the worker with r entered.
+func (d *daemon) publish(r *daemonRepo, shas []string) {
+	d.mu.Lock()
+	for sha := range r.entries {
+		shas = append(shas, sha)
+	}
+	d.mu.Unlock()
+
+	entries := make(map[string]daemonEntry, len(shas))
+	for _, sha := range shas {
+		entry, fresh := r.lookup.Cached(sha)
+		entries[sha] = daemonEntry{entry: entry, fresh: fresh}
+	}
+
+	d.mu.Lock()
+	r.entries = entries
+	d.mu.Unlock()
+}
+
+// refresh has the revisions asked for whose entries have expired fetched,
+// resolving them again so that new commits are picked up. Call from the
+// worker.
+func (d *daemon) refresh() {
+	ctx, end := startSpan(context.Background(), "daemon.refresh")
+	defer end(nil)
+
+	for _, r := range d.repositories() {
+		d.refreshRepo(ctx, r)
+	}
+}
+
+// repositories returns the repositories asked about so far.
+func (d *daemon) repositories() []*daemonRepo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	repos := make([]*daemonRepo, 0, len(d.repos))
+	for _, r := range d.repos {
+		repos = append(repos, r)
+	}
+
+	return repos
+}
+
+// refreshRepo has the revisions asked for in r fetched if their entries
+// have expired. Call from the worker.
+func (d *daemon) refreshRepo(ctx context.Context, r *daemonRepo) {
+	err := func() error {
+		if err := d.enter(r); err != nil {
+			return err
+		}
+
+		d.mu.Lock()
+		revs := make([]string, 0, len(r.revs))
+		for rev := range r.revs {
+			revs = append(revs, rev)
+		}
+		d.mu.Unlock()
+
+		var shas []string
+		for _, rev := range revs {
+			_, sha, err := r.lookup.Repo.Resolve(rev)
+			if err != nil {
+				return err
+			}
+			if r.lookup.Repo.IsPushed(sha) {
+				shas = append(shas, sha)
+			}
+		}
+
+		return d.fetchStale(ctx, r, shas)
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", r.toplevel, err)
+	}
+}
+
+// watch refreshes the repositories whose git directories have changed
+// since it last looked, so that the statuses of commits checked out,
+// committed, fetched or pushed are there before the prompt asks for them.
+// Call from the worker.
+func (d *daemon) watch() {
+	for _, r := range d.repositories() {
+		if r.lookup == nil {
+			continue
+		}
+
+		stamp := gitStamp(r.gitDir, r.commonDir)
+		if stamp == r.stamp {
+			continue
+		}
+		r.stamp = stamp
+
+		ctx, end := startSpan(context.Background(), "daemon.prefetch", "repository", r.toplevel)
+		d.refreshRepo(ctx, r)
+		end(nil)
+	}
+}
+
+// gitStamp returns the times and sizes of the files git writes when HEAD
+// moves, on fetches and pushes, which change whenever they do. Watching
+// them takes a stat each rather than a watch on every ref, but for the
+// remote-tracking branches, which are all stated.
+func gitStamp(gitDir, commonDir string) string {
+	paths := []string{
+		filepath.Join(gitDir, "HEAD"),
+		filepath.Join(gitDir, "logs", "HEAD"),
+		filepath.Join(gitDir, "FETCH_HEAD"),
+		filepath.Join(commonDir, "FETCH_HEAD"),
+		filepath.Join(commonDir, "packed-refs"),
+	}
+	// Pushes only write the remote-tracking branches, which may be nested as
+	// in refs/remotes/origin/feature/x; one added or deleted is a path more
+	// or less
+	filepath.Walk(filepath.Join(commonDir, "refs", "remotes"), func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	var stamp strings.Builder
+	for _, path := range paths {
+		if fi, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&stamp, "%s %d %d\n", path, fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+
+	return stamp.String()
+}
+
+// gitDirOf returns the git directory of the work tree at toplevel: .git,
+// where a linked worktree's .git file points, or toplevel itself if bare.
+func gitDirOf(toplevel string) string {
+	dotGit := filepath.Join(toplevel, ".git")
+	fi, err := os.Stat(dotGit)
+	if err != nil {
+		return toplevel
+	}
+	if fi.IsDir() {
+		return dotGit
+	}
+
+	buf, err := ioutil.ReadFile(dotGit)
+	if err != nil {
+		return dotGit
+	}
+	dir := strings.TrimSpace(strings.TrimPrefix(string(buf), "gitdir:"))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(toplevel, dir)
+	}
+
+	return dir
+}
+
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	if line == "health\n" {
+		d.mu.Lock()
+		fetching := 0
+		for _, r := range d.repos {
+			fetching += len(r.queued)
+		}
+		fmt.Fprintf(conn, "ok\t%d\t%d\n", len(d.repos), fetching)
+		d.mu.Unlock()
+		return
+	}
+
+	fields := strings.SplitN(strings.TrimRight(line, "\n"), "\t", 2)
+	if len(fields) != 2 {
+		fmt.Fprintf(conn, "error: malformed query %q\n", line)
+		return
+	}
+
+	ctx, end := startSpan(context.Background(), "daemon.query", "dir", fields[0], "rev", fields[1])
+	entry, setting, err := d.status(ctx, fields[0], fields[1])
+	end(err)
+	if err != nil {
+		fmt.Fprintf(conn, "error: %s\n", strings.Replace(err.Error(), "\n", " ", -1))
+		return
+	}
+
+	d.mu.Lock()
+	colorUI := d.colorUI
+	d.mu.Unlock()
+
+	completed, total := entry.Progress()
+	fmt.Fprintf(conn, "%s\t%d\t%d\t%s\t%s\t%s\n", entry.Status, completed, total, colorUI, setting.Mark, setting.Color)
+}
+
+// reload reads the settings of the daemon and of the repositories kept
+// again, and returns the refresh and watch intervals. Call from the
+// worker.
+func (d *daemon) reload() (time.Duration, time.Duration) {
+	statusmark.ReloadConfig()
+	if err := os.Chdir(d.dir); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", d.dir, err)
+	}
+	colorUI := colorUI()
+	interval, watchInterval := daemonInterval(), daemonWatchInterval()
+
+	d.mu.Lock()
+	d.colorUI = colorUI
+	d.mu.Unlock()
+
+	for _, r := range d.repositories() {
+		if err := d.enter(r); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", r.toplevel, err)
+			continue
+		}
+		d.publish(r, nil)
+	}
+
+	return interval, watchInterval
+}
+
+// daemonInterval is how often the daemon refreshes expired statuses,
+// github-commit-status.daemonInterval or 10s.
+func daemonInterval() time.Duration {
+	if d := statusmark.ConfigDuration("daemonInterval", 0); d > 0 {
+		return d
+	}
+
+	return 10 * time.Second
+}
+
+// daemonWatchInterval is how often the daemon looks for changes in the git
+// directories of the repositories it keeps,
+// github-commit-status.daemonWatchInterval or 1s.
+func daemonWatchInterval() time.Duration {
+	if d := statusmark.ConfigDuration("daemonWatchInterval", 0); d > 0 {
+		return d
+	}
+
+	return time.Second
+}
+
+// daemonHealth asks the daemon at socket whether it answers queries, and
+// returns its reply.
+func daemonHealth(socket string, timeout time.Duration) (string, error) {
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := fmt.Fprintln(conn, "health"); err != nil {
+		return "", err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "ok\t") {
+		return "", fmt.Errorf("daemon: unhealthy reply %q", line)
+	}
+
+	return strings.TrimRight(line, "\n"), nil
+}
+
+// runDaemonCommand runs "daemon health", which exits with 0 if the daemon
+// at socket answers queries within timeout, printing how many repositories
+// it keeps and fetches it runs, or "daemon install", which has the daemon
+// run as a service listening on socket.
+func runDaemonCommand(args []string, socket string, timeout time.Duration) {
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "health":
+		reply, err := daemonHealth(socket, timeout)
+		dieIf(err)
+
+		fields := strings.Split(reply, "\t")
+		fmt.Printf("ok: %s repositories, %s fetching\n", fields[1], fields[2])
+
+	case "install":
+		runInstallService(args[1:], socket)
+
+	default:
+		die("usage: github-commit-status-mark daemon health|install")
+	}
+}
+
+// runDaemon listens on socket until killed, keeping the caches of the
+// repositories asked about in memory and refreshing them every interval,
+// and whenever their git directories change. It fetches by itself, over
+// connections to the API kept open between fetches. SIGHUP makes it read
+// its settings again.
+func runDaemon(socket string, interval time.Duration) {
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		die(fmt.Sprintf("A daemon is already listening on %s", socket))
+	}
+	// A socket left by a daemon that died is in the way
+	os.Remove(socket)
+
+	listener, err := net.Listen("unix", socket)
+	dieIf(err)
+	defer listener.Close()
+
+	dir, err := os.Getwd()
+	dieIf(err)
+	d := &daemon{
+		repos:   map[string]*daemonRepo{},
+		colorUI: colorUI(),
+		fetches: make(chan daemonFetch, 64),
+		dir:     dir,
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go d.work(interval, hup)
+
+	for {
+		conn, err := listener.Accept()
+		dieIf(err)
+		go d.serve(conn)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// printDetail prints a line for every context: its mark, name, state,
+// description and target URL.
+func printDetail(contexts []statusmark.ContextStatus, l layout) {
+	if len(contexts) == 0 {
+		fmt.Println(statusWord(statusmark.StatusUnknown))
+		return
+	}
+
+	nameWidth := 0
+	for _, c := range contexts {
+		if w := runewidth.StringWidth(l.context(c.Context)); w > nameWidth {
+			nameWidth = w
+		}
+	}
+
+	for _, c := range contexts {
+		conf, ok := statusConfiguration[c.State]
+		if !ok {
+			conf = statusConfiguration[statusmark.StatusUnknown]
+		}
+		printStatus(c.State, " ")
+		rest := l.narrower(runewidth.StringWidth(conf.mark) + 1)
+
+		fields := []string{runewidth.FillRight(l.context(c.Context), nameWidth), statusWord(c.State)}
+		if c.Description != "" && c.Description != c.State {
+			fields = append(fields, c.Description)
+		}
+		if c.TargetURL != "" {
+			fields = append(fields, c.TargetURL)
+		}
+		rest.println("%s", strings.Join(fields, "  "))
+	}
+}
+
+func printWarnings(contexts []statusmark.ContextStatus, l layout) {
+	patterns := statusmark.WarningContextPatterns()
+
+	fmt.Println()
+	for _, c := range contexts {
+		if statusmark.IsFailing(c.State) && statusmark.MatchContext(c.Context, patterns) {
+			l.println("warning: %s %s %s", l.context(c.Context), statusWord(c.State), c.Description)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// lineRange is an inclusive range of line numbers.
+type lineRange struct {
+	start, end int
+}
+
+// changedLines maps each file to the lines added or modified in it.
+type changedLines map[string][]lineRange
+
+func (c changedLines) overlaps(path string, start, end int) bool {
+	if end < start {
+		end = start
+	}
+
+	for _, r := range c[path] {
+		if start <= r.end && r.start <= end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultBranch returns the branch remote/HEAD points to, as set by git
+// clone or git remote set-head.
+func defaultBranch(remote string) (string, error) {
+	buf, err := exec.Command("git", "symbolic-ref", "--quiet", "--short", "refs/remotes/"+remote+"/HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s/HEAD is not set; run 'git remote set-head %s --auto' or give the base explicitly", remote, remote)
+	}
+
+	return strings.TrimSpace(string(buf)), nil
+}
+
+var reHunkHeader = regexp.MustCompile(`^@@ -\S+ \+(\d+)(?:,(\d+))? @@`)
+
+// diffLines returns the lines rev changed since its merge-base with base,
+// computed locally.
+func diffLines(base, rev string) (changedLines, error) {
+	mergeBase, err := statusmark.RunGit("merge-base", base, rev)
+	if err != nil {
+		return nil, err
+	}
+	// Without prefixes, whatever diff.noprefix and diff.mnemonicPrefix say
+	diff, err := statusmark.RunGit("diff", "-U0", "--no-color", "--no-ext-diff", "--no-prefix", mergeBase, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := changedLines{}
+	var path string
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "+++ ") {
+			path = strings.TrimPrefix(line, "+++ ")
+			continue
+		}
+
+		m := reHunkHeader.FindStringSubmatch(line)
+		if m == nil || path == "" {
+			continue
+		}
+
+		start, _ := strconv.Atoi(m[1])
+		count := 1
+		if m[2] != "" {
+			count, _ = strconv.Atoi(m[2])
+		}
+		if count == 0 {
+			// Only deletions
+			continue
+		}
+
+		changed[path] = append(changed[path], lineRange{start, start + count - 1})
+	}
+
+	return changed, nil
+}
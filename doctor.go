@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+type doctor struct {
+	problems int
+}
+
+func (d *doctor) ok(subject, format string, args ...interface{}) {
+	fmt.Printf("✓ %s: %s\n", subject, fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) ng(subject, format string, args ...interface{}) {
+	d.problems++
+	fmt.Printf("✗ %s: %s\n", subject, fmt.Sprintf(format, args...))
+}
+
+// runDoctor checks everything a status lookup depends on and prints what to
+// fix, exiting non-zero if anything is wrong.
+func runDoctor(repo statusmark.Repository) {
+	d := &doctor{}
+
+	if path, err := exec.LookPath("git"); err != nil {
+		d.ng("git", "git command not found; some repositories and settings need it")
+	} else {
+		d.ok("git", "%s", path)
+	}
+
+	remoteName := statusmark.ConfiguredRemotes(repo)[0]
+	remote, err := statusmark.ParseRemote(repo, remoteName)
+	if err != nil {
+		d.ng("remote", "could not parse the URL of remote %q; is it a GitHub repository?", remoteName)
+		os.Exit(1)
+	}
+	d.ok("remote", "%s/%s on %s", remote.Owner, remote.Name, remote.URL.Host)
+
+	tokenSource, source := statusmark.TokenSource(remote.URL)
+	token := tokenSource != nil
+	if !token {
+		d.ng("token", "no token found; set GITHUB_COMMIT_STATUS_MARK_TOKEN or GH_TOKEN, run login, add the API host to ~/.netrc, set git config github-commit-status.token or tokenCommand, or log in with gh (private repositories need one)")
+	} else if _, err := tokenSource.Token(); err != nil {
+		d.ng("token", "could not be obtained: %s", err)
+	} else {
+		d.ok("token", "found in %s", source)
+	}
+
+	root, err := statusmark.APIRoot(remote.URL)
+	if err != nil {
+		d.ng("api", "%s", err)
+		os.Exit(1)
+	}
+	// As real requests are sent, and not to hang on hosts that drop packets
+	if err := statusmark.CheckConnection(remote.URL, root, 10*time.Second); err != nil {
+		d.ng("tls", "could not connect to %s: %s; give its CA with -ca-file or github-commit-status.caFile, or check http.proxy", root.Host, err)
+	} else {
+		d.ok("tls", "connected to %s", root.Host)
+	}
+
+	// Requests made with the token are only known for GitHub
+	if provider := statusmark.ProviderOf(remote.URL); provider == statusmark.ProviderGitHub {
+		checkAPI(d, remote, token)
+	} else {
+		d.ok("api", "not checked further on %s, a %s host", remote.URL.Host, provider)
+	}
+
+	if state, err := statusmark.NewCache(repo); err != nil {
+		d.ng("cache", "%s", err)
+	} else {
+		cacheDir := filepath.Dir(state.Path())
+		if err := checkWritable(cacheDir); err != nil {
+			d.ng("cache", "%s is not writable: %s", cacheDir, err)
+		} else {
+			d.ok("cache", "%s is writable", cacheDir)
+		}
+	}
+
+	if d.problems > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkAPI checks that the GitHub API of remote answers, and accepts the
+// token if there is one.
+func checkAPI(d *doctor, remote statusmark.Remote, token bool) {
+	client := statusmark.NewAPIClient(remote.URL, statusmark.ClientOptions{RetryPolicy: statusmark.LoadRetryPolicy(statusmark.RetryModePrompt)})
+
+	path := "rate_limit"
+	if token {
+		path = "user"
+	}
+	req, err := client.NewRequest("GET", path, nil)
+	dieIf(err)
+
+	resp, err := client.Do(req, nil)
+	switch {
+	case err != nil && resp != nil && resp.StatusCode == 401:
+		d.ng("token", "rejected by %s; it may be expired or revoked", client.BaseURL)
+	case err != nil:
+		d.ng("api", "%s is not reachable: %s", client.BaseURL, err)
+	default:
+		d.ok("api", "%s is reachable", client.BaseURL)
+		if token {
+			if scopes := resp.Header.Get("X-OAuth-Scopes"); scopes != "" {
+				d.ok("token", "valid, scopes: %s", scopes)
+			} else {
+				d.ok("token", "valid")
+			}
+		}
+	}
+}
+
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".doctor")
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/daviddengcn/go-colortext"
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// iconSets are alternative marks for where ANSI colors are not available,
+// such as notification bodies. Being colored already, they are not colored
+// again. Emoji take two columns, which go-runewidth accounts for when
+// fitting output.
+var iconSets = map[string]map[string]string{
+	"emoji": {
+		statusmark.StatusUnknown:        "⚪",
+		statusmark.StatusFailure:        "🔴",
+		statusmark.StatusPending:        "🟡",
+		statusmark.StatusSuccess:        "🟢",
+		statusmark.StatusWarning:        "🟠",
+		statusmark.StatusError:          "⛔",
+		statusmark.StatusActionRequired: "🔵",
+		statusmark.StatusQueued:         "🟡",
+		statusmark.StatusInProgress:     "🟡",
+		statusmark.StatusLocal:          "🟣",
+		statusmark.StatusNotFound:       "⚫",
+	},
+}
+
+// useIcons replaces the marks in statusConfiguration with the icon set name;
+// "default" keeps them, and "words" uses the colored words for the statuses in
+// the language of the user.
+func useIcons(name string) error {
+	if name == "" || name == "default" {
+		return nil
+	}
+
+	if name == "words" {
+		for status, conf := range statusConfiguration {
+			conf.mark = statusWord(status)
+			statusConfiguration[status] = conf
+		}
+		return nil
+	}
+
+	icons, ok := iconSets[name]
+	if !ok {
+		return fmt.Errorf("no such icon set: %s", name)
+	}
+
+	for status, conf := range statusConfiguration {
+		if icon, ok := icons[status]; ok {
+			conf.mark = icon
+			conf.color = ct.None
+			statusConfiguration[status] = conf
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/motemen/github-commit-status-mark/statusmark"
+	"golang.org/x/term"
+)
+
+// layout fits the lines of verbose output into the terminal. Actions matrix
+// job names alone easily exceed 80 columns.
+type layout struct {
+	// width is the maximum width of a line; 0 means unlimited
+	width int
+	// wrap wraps long lines instead of truncating them
+	wrap bool
+	// contextWidth is the maximum width of a context name; 0 means unlimited
+	contextWidth int
+}
+
+// newLayout returns the layout for width, taking the width of the terminal
+// if it is 0 and stdout is one. A negative width means unlimited.
+func newLayout(width int, wrap bool) layout {
+	if width == 0 {
+		if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			width = w
+		}
+	}
+	if width < 0 {
+		width = 0
+	}
+
+	return layout{
+		width:        width,
+		wrap:         wrap,
+		contextWidth: statusmark.ConfigInt("contextWidth", 40),
+	}
+}
+
+// ellipsize shortens s to width columns, ending it with an ellipsis.
+func ellipsize(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	return runewidth.Truncate(s, width, "…")
+}
+
+// context returns the name of a context shortened to contextWidth.
+func (l layout) context(name string) string {
+	return ellipsize(name, l.contextWidth)
+}
+
+// println prints a line fitted into the width, wrapped or truncated.
+func (l layout) println(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+
+	if l.width > 0 && l.wrap {
+		line = runewidth.Wrap(line, l.width)
+	} else {
+		line = ellipsize(line, l.width)
+	}
+
+	fmt.Println(line)
+}
+
+// narrower returns the layout for what follows n columns already printed.
+func (l layout) narrower(n int) layout {
+	if l.width > 0 {
+		l.width -= n
+		if l.width < 1 {
+			l.width = 1
+		}
+	}
+	return l
+}
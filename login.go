@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+	"golang.org/x/term"
+)
+
+// defaultHost returns the host of origin if run in a clone, or github.com.
+func defaultHost() string {
+	if u, err := statusmark.NormalizeURL(statusmark.GitConfig("--get", "remote.origin.url")); err == nil && u.Host != "" {
+		return u.Host
+	}
+
+	return "github.com"
+}
+
+// storeToken stores token for host in the system keyring, or in the global
+// git config if no keyring is available.
+func storeToken(host, token string) {
+	err := statusmark.StoreToken(host, token)
+	if err == nil {
+		fmt.Printf("Stored the token for %s in the system keyring\n", host)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Could not use the system keyring: %s\n", err)
+	key := fmt.Sprintf("github-commit-status.https://%s.token", host)
+	_, err = statusmark.RunGit("config", "--global", key, token)
+	dieIf(err)
+	fmt.Printf("Stored the token for %s as %s in the global git config\n", host, key)
+}
+
+// runLogin stores a token for a host, obtained by the OAuth device flow or
+// read from the terminal without echo or from stdin, or deletes it. The
+// device flow is used when run in a terminal without -with-token, if an
+// OAuth app is set for the host; the token is asked for otherwise.
+func runLogin(args []string) {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	var (
+		host      = flags.String("host", defaultHost(), "Store the token for `host`")
+		remove    = flags.Bool("delete", false, "Delete the stored token instead")
+		withToken = flags.Bool("with-token", false, "Read a token instead of authorizing in the browser")
+	)
+	flags.Parse(args)
+
+	if *remove {
+		dieIf(statusmark.DeleteToken(*host))
+		fmt.Printf("Deleted the token for %s\n", *host)
+		return
+	}
+
+	var token string
+	isTerminal := term.IsTerminal(int(os.Stdin.Fd()))
+	if !*withToken && isTerminal && !statusmark.CanDeviceLogin(*host) {
+		fmt.Fprintf(os.Stderr, "No OAuth app is set for %s in github-commit-status.oauthClientID to authorize in the browser with\n", *host)
+		*withToken = true
+	}
+	if !*withToken && isTerminal {
+		var err error
+		token, err = statusmark.DeviceLogin(*host, func(userCode, verificationURI string) {
+			fmt.Fprintf(os.Stderr, "Enter the code %s at %s\n", userCode, verificationURI)
+			fmt.Fprintln(os.Stderr, "Waiting for authorization...")
+		})
+		dieIf(err)
+	} else if isTerminal {
+		fmt.Fprintf(os.Stderr, "Token for %s: ", *host)
+		buf, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		dieIf(err)
+		token = string(buf)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			dieIf(err)
+		}
+		token = line
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		die("No token given")
+	}
+
+	storeToken(*host, token)
+}
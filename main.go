@@ -1,277 +1,685 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"net/http"
-	"net/url"
 	"os"
-	"os/exec"
-	osUser "os/user"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 
-	"crypto/tls"
-
-	"code.google.com/p/go-netrc/netrc"
-	"code.google.com/p/goauth2/oauth"
 	"github.com/daviddengcn/go-colortext"
 	"github.com/google/go-github/github"
+	"github.com/motemen/github-commit-status-mark/statusmark"
 )
 
-const (
-	statusUnknown = ""
-	statusFailure = "failure"
-	statusPending = "pending"
-	statusSuccess = "success"
-)
-
-const forever = time.Duration(-1)
-
 var statusConfiguration = map[string]struct {
-	mark     string
-	color    ct.Color
-	cacheFor time.Duration
+	mark  string
+	color ct.Color
 }{
-	statusUnknown: {"?", ct.None, 30 * time.Second},
-	statusFailure: {"✗", ct.Red, forever},
-	statusPending: {"●", ct.Yellow, 10 * time.Second},
-	statusSuccess: {"✓", ct.Green, forever},
+	statusmark.StatusUnknown: {"?", ct.None},
+	statusmark.StatusFailure: {"✗", ct.Red},
+	statusmark.StatusPending: {"●", ct.Yellow},
+	statusmark.StatusSuccess: {"✓", ct.Green},
+	statusmark.StatusWarning: {"!", ct.Magenta},
+	statusmark.StatusError:   {"⊘", ct.Red},
+
+	statusmark.StatusActionRequired: {"◆", ct.Cyan},
+	statusmark.StatusQueued:         {"○", ct.Yellow},
+	statusmark.StatusInProgress:     {"●", ct.Yellow},
+	statusmark.StatusLocal:          {"↑", ct.Blue},
+	statusmark.StatusNotFound:       {"∅", ct.None},
 }
 
-func printStatus(status string) {
+func printStatus(status string, suffix string) {
 	conf, ok := statusConfiguration[status]
 	if !ok {
-		conf = statusConfiguration[statusUnknown]
+		conf = statusConfiguration[statusmark.StatusUnknown]
+	}
+
+	if conf.color == ct.None || !terminalColor() {
+		fmt.Print(conf.mark + suffix)
+		return
 	}
 
 	ct.ChangeColor(conf.color, false, ct.None, false)
-	fmt.Print(conf.mark)
+	fmt.Print(conf.mark + suffix)
 	ct.ResetColor()
 }
 
-func retrieveAPIToken(remoteURL *url.URL) string {
-	var token string
-
-	// try environment variable
-	token = os.Getenv("GITHUB_COMMIT_STATUS_MARK_TOKEN")
-
-	// ..then .netrc
-	if token == "" {
-		if user, _ := osUser.Current(); user != nil {
-			netrcFile := filepath.Join(user.HomeDir, ".netrc")
-			if fi, _ := os.Stat(netrcFile); fi != nil {
-				apiHost := remoteURL.Host
-				if apiHost == "github.com" {
-					apiHost = "api.github.com"
-				}
-
-				machine, _ := netrc.FindMachine(netrcFile, apiHost)
-				// ignore "default" machine
-				if machine != nil && machine.Name != "" {
-					token = machine.Password
-				}
-			}
-		}
+// printMark prints the mark for status, formatted by p if given.
+func printMark(p *preset, status string, suffix string) {
+	if p == nil {
+		printStatus(status, suffix)
+		return
 	}
 
-	// ..then git config
-	if token == "" {
-		token = runGit("config", "--get-urlmatch", "github-commit-status.token", remoteURL.String())
-	}
-
-	return token
-}
-
-type persistentState struct {
-	Revisions map[string]revisionEntry
-	path      string
-}
+	out, err := p.render(status, suffix)
+	dieIf(err)
 
-type revisionEntry struct {
-	Status       string
-	LastModified int64
+	fmt.Print(out)
 }
 
-func (state *persistentState) restore() error {
-	cacheFile, err := os.Open(state.path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// ok
-		} else {
-			return err
-		}
+func targetRevision(args []string) string {
+	rev := "HEAD"
+	if len(args) >= 1 {
+		rev = args[0]
 	}
 
-	json.NewDecoder(cacheFile).Decode(state)
-
-	return nil
+	return rev
 }
 
-func (state *persistentState) save() error {
-	cacheDir, _ := filepath.Split(state.path)
+func die(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
+}
 
-	err := os.MkdirAll(cacheDir, 0777)
+func dieIf(err error) {
 	if err != nil {
-		return err
+		die(err.Error())
 	}
+}
 
-	cacheFile, err := os.Create(state.path)
-	if err != nil {
-		return err
+// requireGitHub dies unless remote is on GitHub, for what only its API can
+// do.
+func requireGitHub(remote statusmark.Remote, what string) {
+	if p := statusmark.ProviderOf(remote.URL); p != statusmark.ProviderGitHub {
+		die(fmt.Sprintf("%s: only supported on GitHub, and %s is on %s", what, remote.URL.Host, p))
 	}
-
-	return json.NewEncoder(cacheFile).Encode(state)
 }
 
-func normalizeURL(urlString string) (*url.URL, error) {
-	reScheme := regexp.MustCompile(`^[\w+]+://`)
+// markSuffix returns the text following the mark, which is the number of
+// completed and total contexts like "3/7" for a pending commit when progress
+// is requested.
+func markSuffix(entry statusmark.Entry, progress bool) string {
+	completed, total := entry.Progress()
+	return progressSuffix(entry.Status, completed, total, progress)
+}
 
-	urlString = reScheme.ReplaceAllLiteralString(urlString, "https://")
-	if strings.HasPrefix(urlString, "https://") == false {
-		urlString = "https://" + strings.Replace(urlString, ":", "/", 1)
+func progressSuffix(status string, completed, total int, progress bool) string {
+	if !progress || !statusmark.IsPending(status) || total == 0 {
+		return ""
 	}
 
-	return url.Parse(strings.TrimSuffix(urlString, ".git"))
+	return fmt.Sprintf("%d/%d", completed, total)
 }
 
-func targetRevision(args []string) string {
-	rev := "HEAD"
-	if len(args) >= 1 {
-		rev = args[0]
+// exitCode returns the exit status for -exit-code: 0 for success, 1 for
+// failure, 2 while waiting and 3 when unknown.
+func exitCode(status string) int {
+	switch {
+	case status == statusmark.StatusSuccess || status == statusmark.StatusWarning:
+		return 0
+	case statusmark.IsFailing(status):
+		return 1
+	case statusmark.IsPending(status) || status == statusmark.StatusActionRequired:
+		return 2
+	default:
+		return 3
 	}
-	rev = runGit("rev-parse", rev)
+}
 
-	return rev
+// subcommands are the words taken as subcommands rather than revisions.
+var subcommands = map[string]bool{
+	"install-alias": true,
+	"login":         true,
+	"set":           true,
+	"doctor":        true,
+	"annotations":   true,
+	"ui":            true,
+	"explain":       true,
+	"annotate-log":  true,
+	"cache":         true,
+	"daemon":        true,
 }
 
-func runGit(command ...string) string {
-	cmd := exec.Command("git", command...)
-	cmd.Stderr = os.Stderr
+// ttlFlag sets how long entries of a status stay fresh, as status=duration
+// or status=forever.
+type ttlFlag struct{}
 
-	buf, err := cmd.Output()
-	if err != nil {
-		die(fmt.Sprintf("'git %s' failed: %s", strings.Join(command, " "), err))
+func (ttlFlag) String() string {
+	return ""
+}
+
+func (ttlFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("want status=duration: %s", v)
 	}
 
-	return strings.TrimRight(string(buf), "\n")
+	return statusmark.SetCacheFor(kv[0], kv[1])
 }
 
-func die(message string) {
-	fmt.Fprintln(os.Stderr, message)
-	os.Exit(1)
+// globList collects the globs of a repeated flag.
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, " ")
 }
 
-func dieIf(err error) {
-	if err != nil {
-		die(err.Error())
-	}
+func (g *globList) Set(glob string) error {
+	*g = append(*g, glob)
+	return nil
 }
 
 func main() {
 	var (
-		useCache    = flag.Bool("cached", false, "Output cached status")
+		useCache    = flag.Bool("cached", false, "Output cached status only, never asking the API")
 		updateCache = flag.Bool("update", false, "Force fetch status")
+		verbose     = flag.Bool("verbose", false, "Show who is responsible for a failing commit")
+		progress    = flag.Bool("progress", false, "Show completed/total checks while pending")
+		workDir     = flag.String("C", "", "Run as if started in `dir`")
+		dryRun      = flag.Bool("dry-run", false, "Print the API requests that would be made without sending them")
 	)
+	flag.StringVar(&statusmark.CAFile, "ca-file", "", "Verify the API host's certificate against the CAs in `file` too")
+	flag.StringVar(&statusmark.APIBase, "api-base", "", "Use the REST API at `url` (default: github-commit-status.apiBase, or as found for the host)")
+	flag.BoolVar(&statusmark.Insecure, "insecure", false, "Do not verify the API host's certificate")
+	flag.StringVar(&statusmark.Profile, "profile", statusmark.Profile, "Use settings of the configuration profile `name`")
+	flag.Var(ttlFlag{}, "ttl", "Keep entries of a status fresh for a duration, as `status=duration` or status=forever, over <status>.cacheFor (may be repeated)")
+	flag.StringVar(&statusmark.CacheDir, "cache-dir", "", "Keep caches in `dir` (default: github-commit-status.cacheDir, or the user's cache directory)")
+	presetName := flag.String("preset", "", "Format output with the preset `name` (zsh, bash, tmux or one defined in git config)")
+	format := flag.String("format", "", "Print the mark for `program`: tmux, with its style markup and without waiting for the API, for status-right as #(github-commit-status-mark -format tmux -C '#{pane_current_path}')")
+	escape := flag.String("escape", "", "Wrap color codes for the prompt of `shell` (zsh or bash), so that they do not count towards its length")
+	byCategory := flag.Bool("categories", false, "Show one mark per context category")
+	upstream := flag.Bool("upstream", false, "Look up statuses on the repository origin was forked from")
+	graphQL := flag.Bool("graphql", false, "Ask for statuses and check runs in one GraphQL query instead of a REST request each, falling back to REST on hosts without it")
+	associatedPR := flag.Bool("associated-pr", false, "Prefer the status check roll-up of the head of the pull request containing the commit")
+	var includeContexts, excludeContexts globList
+	flag.Var(&includeContexts, "context", "Only roll up contexts matching `glob` (may be repeated)")
+	flag.Var(&excludeContexts, "exclude-context", "Do not roll up contexts matching `glob` (may be repeated)")
+	detail := flag.Bool("detail", false, "List every context with its state, description and target URL")
+	jsonOutput := flag.Bool("json", false, "Print the result as JSON")
+	sexp := flag.Bool("sexp", false, "Print the result as an Emacs Lisp plist")
+	query := flag.String("query", "", "Print the parts of the JSON result selected by `filter`, a subset of jq such as '.contexts[] | select(.state == \"failure\") | .name'")
+	withExitCode := flag.Bool("exit-code", false, "Exit with 0 for success, 1 for failure, 2 for pending and 3 for unknown")
+	watch := flag.Bool("watch", false, "Poll until the status settles, or stays unknown for github-commit-status.watchUnknownTimeout (default 1m), printing the mark whenever it changes")
+	watchInterval := flag.Duration("watch-interval", 0, "Poll every `duration` with -watch, for longer while queued (default: github-commit-status.watchInterval, or 10s)")
+	flag.StringVar(&colorFlag, "color", "", "Color marks `when`: auto (on terminals), always or never (default: never if NO_COLOR is set, else github-commit-status.color or color.ui)")
+	icons := flag.String("icons", "", "Use the marks of the icon `set` (default, emoji or words)")
+	width := flag.Int("width", 0, "Fit verbose output into `columns` (default: the terminal width; -1 for unlimited)")
+	wrap := flag.Bool("wrap", false, "Wrap long lines of verbose output instead of truncating them")
+	branch := flag.String("branch", "", "Show the status of the current head of the remote `branch`, asking the API instead of the local repository")
+	flag.StringVar(&statusmark.RemoteName, "remote", "", "Look up the repository of the git remote `name` (default: github-commit-status.remotes, or origin and then the remote of the current branch)")
+	remoteRepo := flag.String("repo", "", "Look up `owner/name` (or host/owner/name, or a URL) instead of the repository in the working directory, without needing git")
+	sha := flag.String("sha", "", "Look up `commit` instead of HEAD")
+	batch := flag.Bool("stdin", false, "Print \"<sha> <mark>\" for every revision read from stdin, one per line")
+	requiredOnly := flag.Bool("required-only", false, "Roll up only the contexts required by the branch protection of the default branch (or github-commit-status.protectedBranch)")
+	pullRequest := flag.Bool("pr", false, "Show the status of the open pull request of the current branch, with the checks of its merge commit")
+	async := flag.Bool("async", false, "Show an expired status from the cache at once and refresh it in the background")
+	runAsDaemon := flag.Bool("daemon", false, "Keep statuses in memory and answer queries on the -socket until killed")
+	socket := flag.String("socket", os.Getenv("GCSM_SOCKET"), "Ask the daemon listening on `path` first; with -daemon, listen on it")
+	showRateLimit := flag.Bool("rate-limit", false, "Print how many API requests are left until the rate limit resets")
+	offline := flag.Bool("offline", false, "Report from the cache only, never asking the API, and decorate stale marks (also when there is no network)")
+	timeout := flag.Duration("timeout", 0, "Give up on the API after `duration`, showing the cached or unknown mark (default: github-commit-status.timeout, or 2s; not with -watch)")
 	flag.Parse()
 
-	var state = persistentState{
-		path: filepath.Join(
-			runGit("rev-parse", "--show-toplevel"),
-			".github-commit-status",
-			"cache",
-		),
+	switch colorFlag {
+	case "", colorAuto, colorAlways, colorNever:
+	default:
+		die(fmt.Sprintf("-color: must be auto, always or never: %s", colorFlag))
 	}
-	dieIf(state.restore())
 
-	rev := targetRevision(flag.Args())
+	switch *format {
+	case "":
+	case "tmux":
+		// tmux runs it again every status-interval and waits for none, so
+		// the cache is shown at once and refreshed in the background
+		if *presetName == "" {
+			*presetName = escapeTmux
+		}
+		*async = true
+	default:
+		die(fmt.Sprintf("-format: must be tmux: %s", *format))
+	}
 
-	cachedRevisionEntry := state.Revisions[rev]
+	if statusmark.CacheDir != "" {
+		// Before -C changes what it is relative to
+		dir, err := filepath.Abs(statusmark.CacheDir)
+		dieIf(err)
+		statusmark.CacheDir = dir
+	}
 
-	conf, ok := statusConfiguration[cachedRevisionEntry.Status]
-	if !ok {
-		conf = statusConfiguration[statusUnknown]
+	if *workDir != "" {
+		dieIf(os.Chdir(*workDir))
 	}
 
-	if *updateCache {
-		*useCache = false
-	} else {
-		exp := conf.cacheFor
-		if exp == forever || time.Now().Before(time.Unix(cachedRevisionEntry.LastModified, 0).Add(exp)) {
-			*useCache = true
+	dieIf(initTracing(*runAsDaemon))
+
+	if *runAsDaemon {
+		if *socket == "" {
+			*socket = defaultSocketPath()
 		}
+		runDaemon(*socket, daemonInterval())
 	}
 
-	if *useCache {
-		printStatus(cachedRevisionEntry.Status)
+	// Only the plain mark is answered by the daemon, which saves running git
+	plainMark := !*useCache && !*updateCache && !*verbose && !*dryRun && !*byCategory && !*batch &&
+		!*detail && !*jsonOutput && !*sexp && *query == "" && !*watch && *icons == "" &&
+		*branch == "" && *remoteRepo == "" && statusmark.RemoteName == "" && *sha == "" && !*pullRequest && !*requiredOnly && !*showRateLimit && !*offline &&
+		len(includeContexts) == 0 && len(excludeContexts) == 0 && flag.NArg() <= 1 && !subcommands[flag.Arg(0)]
+	if *socket != "" && plainMark {
+		dir, err := os.Getwd()
+		dieIf(err)
+
+		// When the daemon is not running, look up as usual
+		// The daemon answers from memory at once, so this is only exceeded
+		// when it hangs
+		daemonTimeout := *timeout
+		if daemonTimeout == 0 {
+			daemonTimeout = statusmark.ConfigDuration("timeout", 2*time.Second)
+		}
+		if reply, err := queryDaemon(*socket, dir, targetRevision(flag.Args()), daemonTimeout); err == nil {
+			colorUISetting = reply.colorUI
+			dieIf(useStatusSettings(map[string]statusmark.StatusSetting{reply.status: reply.setting}))
+
+			p, err := outputPreset(*presetName, *escape)
+			dieIf(err)
+			printMark(p, reply.status, progressSuffix(reply.status, reply.completed, reply.total, *progress))
+
+			if *withExitCode {
+				os.Exit(exitCode(reply.status))
+			}
+			os.Exit(0)
+		}
+	}
+
+	if flag.Arg(0) == "install-alias" {
+		runInstallAlias(flag.Args()[1:])
 		os.Exit(0)
 	}
 
-	// Parse remote URL
-	remoteURL, err := normalizeURL(runGit("config", "remote.origin.url"))
-	if err != nil {
-		die(fmt.Sprintf("Error while parsing URL: %s", err))
+	if flag.Arg(0) == "login" {
+		runLogin(flag.Args()[1:])
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "daemon" {
+		if *socket == "" {
+			*socket = defaultSocketPath()
+		}
+		if *timeout == 0 {
+			*timeout = statusmark.ConfigDuration("timeout", 2*time.Second)
+		}
+		runDaemonCommand(flag.Args()[1:], *socket, *timeout)
+		os.Exit(0)
+	}
+
+	var repo statusmark.Repository
+	if *remoteRepo != "" {
+		var err error
+		repo, err = statusmark.RemoteOnlyRepository(*remoteRepo)
+		dieIf(err)
+	} else {
+		repo = statusmark.OpenRepository()
+	}
+
+	args := flag.Args()
+	var trail *statusmark.Explanation
+	// logArgs are the git log arguments of annotate-log, if run
+	var logArgs []string
+
+	switch flag.Arg(0) {
+	case "set":
+		runSet(repo, flag.Args()[1:], *dryRun)
+		os.Exit(0)
+
+	case "doctor":
+		runDoctor(repo)
+		os.Exit(0)
+
+	case "annotations":
+		runAnnotations(repo, flag.Args()[1:], *dryRun)
+		os.Exit(0)
+
+	case "ui":
+		runUI(repo, flag.Args()[1:])
+		os.Exit(0)
+
+	case "annotate-log":
+		logArgs = args[1:]
+		args = nil
+
+	case "explain":
+		// Look up as usual, then tell how it went
+		args = args[1:]
+		trail = &statusmark.Explanation{}
+
+	case "cache":
+		runCache(repo, flag.Args()[1:])
+		os.Exit(0)
+	}
+
+	var (
+		toplevel, rev string
+		err           error
+	)
+	switch {
+	case *branch != "" || *batch || logArgs != nil || *showRateLimit:
+		toplevel, err = repo.Toplevel()
+	case *sha != "":
+		toplevel, rev, err = repo.Resolve(*sha)
+	case *remoteRepo != "" && len(args) == 0:
+		die("-repo needs the commit, with -sha or as an argument")
+	default:
+		toplevel, rev, err = repo.Resolve(targetRevision(args))
 	}
+	dieIf(err)
+	dieIf(statusmark.LoadRepoConfig(toplevel))
 
-	parts := strings.Split(remoteURL.Path, "/")
-	if len(parts) < 3 {
-		die(fmt.Sprintf("Could not parse: %q", remoteURL))
+	state, err := statusmark.NewCache(repo)
+	dieIf(err)
+	dieIf(state.Restore())
+
+	// A hung API call or slow DNS must not freeze the shell
+	if *timeout == 0 {
+		*timeout = statusmark.ConfigDuration("timeout", 2*time.Second)
 	}
+	ctx, cancel := context.WithTimeout(tracingContext(), *timeout)
+	defer cancel()
 
-	user := parts[1]
-	repo := parts[2]
+	if !*offline {
+		*offline = statusmark.ConfigBool("offline") || statusmark.NetworkDown()
+	}
+	if *offline && (*branch != "" || *pullRequest || *showRateLimit || *watch) {
+		die("-branch, -pr, -rate-limit and -watch need the API, which is not asked offline")
+	}
+
+	lookup := &statusmark.Lookup{
+		Repo:        repo,
+		Cache:       state,
+		Trail:       trail,
+		DryRun:      *dryRun,
+		Upstream:    *upstream || statusmark.ConfigBool("upstream"),
+		PullRequest: *associatedPR || statusmark.ConfigBool("associatedPullRequest"),
+		GraphQL:     *graphQL || statusmark.ConfigBool("graphql"),
+		Include:     includeContexts,
+		Exclude:     excludeContexts,
+		Context:     ctx,
+	}
+
+	if *branch != "" || *pullRequest || *requiredOnly || *showRateLimit {
+		remote, err := statusmark.ParseRemote(repo, statusmark.ConfiguredRemotes(repo)[0])
+		dieIf(err)
+		requireGitHub(remote, "-branch, -pr, -required-only and -rate-limit")
+	}
 
-	// Setup client
-	var httpClient *http.Client
+	if *showRateLimit {
+		remote, err := statusmark.ParseRemote(repo, statusmark.ConfiguredRemotes(repo)[0])
+		dieIf(err)
 
-	token := retrieveAPIToken(remoteURL)
-	if token != "" {
-		t := &oauth.Transport{
-			Token: &oauth.Token{AccessToken: token},
+		var limit *statusmark.RateLimit
+		err = state.Update(tracingContext(), func() (err error) {
+			limit, err = lookup.FetchRateLimit(remote)
+			return err
+		})
+		if err != nil {
+			die(fmt.Sprintf("Error while fetching the rate limit: %s", err))
+		}
+		if limit == nil {
+			fmt.Printf("%s: no rate limit\n", remote.URL.Host)
+		} else {
+			reset := limit.ResetTime()
+			fmt.Printf("%s: %d of %d requests left, reset at %s (in %s)\n", remote.URL.Host, limit.Remaining, limit.Limit, reset.Format("15:04:05"), time.Until(reset).Round(time.Second))
 		}
-		httpClient = t.Client()
+		os.Exit(0)
 	}
 
-	// Handle GitHub:Enterprise domains
-	if remoteURL.Host != "github.com" {
-		t := http.DefaultTransport.(*http.Transport)
-		t.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
+	if *branch != "" {
+		remote, err := statusmark.ParseRemote(repo, statusmark.ConfiguredRemotes(repo)[0])
+		dieIf(err)
+
+		rev, err = statusmark.BranchHead(lookup.APIClient(remote), remote, *branch)
+		if err != nil {
+			die(fmt.Sprintf("Error while fetching branch %s: %s", *branch, err))
 		}
+		trail.Add("branch: %s points to %s on %s/%s", *branch, rev, remote.Owner, remote.Name)
 	}
 
-	client := github.NewClient(httpClient)
+	if *requiredOnly {
+		protected := statusmark.ConfigValue("protectedBranch")
+		if protected == "" {
+			remoteName := statusmark.ConfiguredRemotes(repo)[0]
+			b, err := defaultBranch(remoteName)
+			dieIf(err)
+			protected = strings.TrimPrefix(b, remoteName+"/")
+		}
 
-	if remoteURL.Host != "github.com" {
-		u, err := url.Parse(fmt.Sprintf("https://%s/api/v3/", remoteURL.Host))
-		dieIf(err)
+		required, err := lookup.RequiredContexts(protected)
+		if err != nil {
+			die(fmt.Sprintf("Error while fetching the protection of %s: %s", protected, err))
+		}
+		if len(required) == 0 {
+			trail.Add("protection: %s requires no contexts, so all are rolled up", protected)
+		}
+		lookup.Required = required
+	}
+
+	// The pull request's status is cached apart from its head's
+	cacheKey := rev
+	var pull *statusmark.PullRequest
+	if *pullRequest {
+		current := repo.Branch()
+		if current == "" {
+			die("-pr needs a branch checked out")
+		}
 
-		client.BaseURL = u
+		var err error
+		pull, err = lookup.OpenPullRequest(current)
+		if err != nil {
+			die(fmt.Sprintf("Error while fetching pull requests: %s", err))
+		}
+		if pull == nil {
+			die(fmt.Sprintf("No open pull request for %s", current))
+		}
+		rev, cacheKey = pull.HeadSHA, pull.Key()
 	}
 
-	statuses, _, err := client.Repositories.ListStatuses(user, repo, rev, nil)
-	if err != nil {
-		die(fmt.Sprintf("Error while fetching status: %s", err))
+	if *icons == "" {
+		*icons = statusmark.ConfigValue("icons")
+	}
+	dieIf(useIcons(*icons))
+	dieIf(useStatusSettings(statusmark.StatusSettings()))
+
+	p, err := outputPreset(*presetName, *escape)
+	dieIf(err)
+
+	if *batch || logArgs != nil {
+		// The lock is held throughout, as what the workers fetch is merged
+		// into the cache only at the end
+		dieIf(state.Update(tracingContext(), func() error {
+			if logArgs != nil {
+				runAnnotateLog(lookup, p, logArgs, statusmark.ConfigInt("concurrency", 4))
+			} else {
+				runBatch(lookup, p, os.Stdin, statusmark.ConfigInt("concurrency", 4))
+			}
+			return nil
+		}))
+		os.Exit(0)
 	}
 
-	thisStatus := revisionEntry{
-		Status:       statusUnknown,
-		LastModified: time.Now().Unix(),
+	if *dryRun {
+		_, _, _, err := lookup.Fetch(rev)
+		dieIf(err)
+		os.Exit(0)
 	}
 
-	if len(statuses) > 0 {
-		thisStatus.Status = *statuses[0].State
+	if *watch {
+		if *watchInterval == 0 {
+			*watchInterval = statusmark.ConfigDuration("watchInterval", 10*time.Second)
+		}
+		lookup.RetryMode = statusmark.RetryModeWatch
+		lookup.Context = nil
+
+		entry, err := lookup.Watch(rev, *watchInterval, func(entry statusmark.Entry) {
+			printMark(p, entry.Status, markSuffix(entry, *progress))
+			fmt.Println()
+		})
+		dieIf(err)
+		if *withExitCode {
+			os.Exit(exitCode(entry.Status))
+		}
+		os.Exit(0)
 	}
 
-	printStatus(thisStatus.Status)
+	// -cached, before a fresh entry makes the cache be used anyway
+	cachedOnly := *useCache
+	entry, fresh := lookup.Cached(cacheKey)
+	// Offline, what is not fresh may well be stale and is marked so
+	var staleSuffix string
+	if *offline {
+		trail.Add("offline: the API is not asked")
+		if !fresh {
+			staleSuffix = statusmark.ConfigValue("offlineSuffix")
+			if staleSuffix == "" {
+				staleSuffix = "~"
+			}
+		}
+		*useCache = true
+		*updateCache = false
+	}
+	if *updateCache {
+		*useCache = false
+	} else if fresh {
+		*useCache = true
+	} else if !*useCache && pull == nil && (*async || statusmark.ConfigBool("async")) {
+		dieIf(refreshInBackground(toplevel, *remoteRepo, rev, lookup.Upstream, lookup.PullRequest, lookup.GraphQL))
+		trail.Add("cache: refreshing in the background, showing the expired entry")
+		*useCache = true
+	}
 
-	if state.Revisions == nil {
-		state.Revisions = map[string]revisionEntry{}
+	var (
+		remote statusmark.Remote
+		client *github.Client
+	)
+	// The cache is only saved under its lock, so that what another
+	// invocation fetched is never overwritten with an older copy, and not at
+	// all when it was only read
+	unlock := func() {}
+	locked := false
+	if !*useCache {
+		// Only one invocation fetches at a time, and those that waited for it
+		// use what it found
+		var err error
+		if unlock, err = state.Lock(ctx); err != nil {
+			trail.Add("lock: %s; what is fetched is not cached", err)
+			unlock = func() {}
+		} else {
+			locked = true
+			dieIf(state.Restore())
+			if latest, fresh := lookup.Cached(cacheKey); fresh && !*updateCache {
+				entry = latest
+				*useCache = true
+			}
+		}
+	}
+	if *useCache {
+		state.RecordHit()
+		if entry.Rule != "" {
+			trail.Add("rule: %s gave %q", entry.Rule, entry.Status)
+		}
+	} else {
+		state.Stats.Misses++
+
+		// Rather than break the prompt, show the expired entry if the API
+		// could not be reached even after retrying
+		expired := entry
+		var err error
+		if pull != nil {
+			entry, err = lookup.FetchPullRequest(pull)
+		} else {
+			entry, remote, client, err = lookup.Fetch(rev)
+		}
+		switch {
+		case err == nil:
+		case ctx.Err() != nil && expired.LastModified == 0:
+			trail.Add("timeout: no answer within %s; showing the unknown mark", *timeout)
+			entry = statusmark.Entry{Status: statusmark.StatusUnknown, Rule: "timed out"}
+		case expired.LastModified == 0 || *updateCache:
+			// What was learned on the way is kept, such as that the server
+			// is too old, not to be asked again
+			if locked {
+				state.Save()
+			}
+			unlock()
+			die(err.Error())
+		default:
+			trail.Add("cache: %s; showing the expired entry", err)
+			entry = expired
+			*useCache = true
+		}
+	}
+	if locked {
+		_, endSave := startSpan(ctx, "cache.save", "path", state.Path())
+		err = state.Save()
+		endSave(err)
+	} else if *useCache {
+		// The hit is counted and the entry kept from being pruned as unused,
+		// under the lock all the same; rather than hold up the prompt, they
+		// are given up if it is not free in time
+		if err := state.Update(ctx, nil); err != nil {
+			trail.Add("lock: %s; the hit is not recorded", err)
+		}
+	}
+	unlock()
+	dieIf(err)
+	entry = lookup.Selected(entry)
+
+	if *width == 0 {
+		*width = statusmark.ConfigInt("width", 0)
+	}
+	l := newLayout(*width, *wrap || statusmark.ConfigBool("wrap"))
+
+	if *query != "" {
+		dieIf(printQuery(*query, statusmark.NewStatus(entry, rev, *useCache)))
+	} else if *jsonOutput {
+		dieIf(json.NewEncoder(os.Stdout).Encode(statusmark.NewStatus(entry, rev, *useCache)))
+	} else if *detail {
+		printDetail(entry.Contexts, l)
+	} else if *sexp {
+		printSexp(entry, rev, *useCache)
+	} else if categories := statusmark.ConfiguredCategories(); *byCategory && len(categories) > 0 {
+		for i, c := range categories {
+			if i > 0 {
+				fmt.Print(" ")
+			}
+			fmt.Print(c.Label)
+			printMark(p, statusmark.CategoryStatus(c, entry.Contexts), "")
+		}
+	} else {
+		printMark(p, entry.Status, markSuffix(entry, *progress)+staleSuffix)
 	}
-	state.Revisions[rev] = thisStatus
 
-	dieIf(state.save())
+	// Who to blame is not cached, so not shown from the cache only
+	if *verbose && statusmark.IsFailing(entry.Status) && !*offline && !cachedOnly {
+		if remote.URL == nil {
+			remote, err = statusmark.ParseRemote(repo, statusmark.ConfiguredRemotes(repo)[0])
+			dieIf(err)
+		}
+		// Who to blame is only asked of GitHub
+		if statusmark.ProviderOf(remote.URL) == statusmark.ProviderGitHub {
+			if client == nil {
+				client = lookup.APIClient(remote)
+			}
+			// The cache is saved by now, so that what was fetched is kept
+			dieIf(printBlame(client, remote, rev, l))
+		}
+	}
+	if *verbose && entry.Status == statusmark.StatusWarning {
+		printWarnings(entry.Contexts, l)
+	}
+	if *verbose && entry.Status == statusmark.StatusNotFound {
+		fmt.Printf("\n%s\n", entry.Rule)
+	}
+	if trail != nil {
+		fmt.Println()
+		for _, line := range trail.Lines {
+			fmt.Println(line)
+		}
+	}
+
+	if *withExitCode {
+		os.Exit(exitCode(entry.Status))
+	}
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// statusWords is the catalog of words for statuses and context states, by
+// language.
+var statusWords = map[string]map[string]string{
+	"en": {
+		statusmark.StatusUnknown:        "unknown",
+		statusmark.StatusFailure:        "failure",
+		statusmark.StatusPending:        "pending",
+		statusmark.StatusSuccess:        "success",
+		statusmark.StatusWarning:        "warning",
+		statusmark.StatusActionRequired: "action required",
+		statusmark.StatusQueued:         "queued",
+		statusmark.StatusInProgress:     "in progress",
+		statusmark.StatusLocal:          "not pushed",
+		statusmark.StatusNotFound:       "not found",
+		statusmark.StatusError:          "error",
+	},
+	"ja": {
+		statusmark.StatusUnknown:        "不明",
+		statusmark.StatusFailure:        "失敗",
+		statusmark.StatusPending:        "保留中",
+		statusmark.StatusSuccess:        "成功",
+		statusmark.StatusWarning:        "警告",
+		statusmark.StatusActionRequired: "要対応",
+		statusmark.StatusQueued:         "待機中",
+		statusmark.StatusInProgress:     "実行中",
+		statusmark.StatusLocal:          "未プッシュ",
+		statusmark.StatusNotFound:       "見つかりません",
+		statusmark.StatusError:          "エラー",
+	},
+	"de": {
+		statusmark.StatusUnknown:        "unbekannt",
+		statusmark.StatusFailure:        "fehlgeschlagen",
+		statusmark.StatusPending:        "ausstehend",
+		statusmark.StatusSuccess:        "erfolgreich",
+		statusmark.StatusWarning:        "Warnung",
+		statusmark.StatusActionRequired: "Aktion erforderlich",
+		statusmark.StatusQueued:         "in Warteschlange",
+		statusmark.StatusInProgress:     "läuft",
+		statusmark.StatusLocal:          "nicht gepusht",
+		statusmark.StatusNotFound:       "nicht gefunden",
+		statusmark.StatusError:          "Fehler",
+	},
+	"fr": {
+		statusmark.StatusUnknown:        "inconnu",
+		statusmark.StatusFailure:        "échec",
+		statusmark.StatusPending:        "en attente",
+		statusmark.StatusSuccess:        "succès",
+		statusmark.StatusWarning:        "avertissement",
+		statusmark.StatusActionRequired: "action requise",
+		statusmark.StatusQueued:         "en file d'attente",
+		statusmark.StatusInProgress:     "en cours",
+		statusmark.StatusLocal:          "non poussé",
+		statusmark.StatusNotFound:       "introuvable",
+		statusmark.StatusError:          "erreur",
+	},
+}
+
+// language returns the language of status words, from
+// github-commit-status.language or the locale as in LC_ALL=ja_JP.UTF-8,
+// falling back to English.
+func language() string {
+	lang := statusmark.ConfigValue("language")
+	if lang == "" {
+		for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+			if lang = os.Getenv(name); lang != "" {
+				break
+			}
+		}
+	}
+
+	// Only the language part of e.g. ja_JP.UTF-8 matters
+	fields := strings.FieldsFunc(lang, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.' || r == '@'
+	})
+	if len(fields) > 0 {
+		if _, ok := statusWords[strings.ToLower(fields[0])]; ok {
+			return strings.ToLower(fields[0])
+		}
+	}
+
+	return "en"
+}
+
+var (
+	wordLanguage     string
+	wordLanguageOnce sync.Once
+)
+
+// statusWord returns the word for a status or context state in the language
+// of the user; states missing from the catalog are returned as they are.
+func statusWord(state string) string {
+	wordLanguageOnce.Do(func() { wordLanguage = language() })
+
+	if word, ok := statusWords[wordLanguage][state]; ok {
+		return word
+	}
+	if word, ok := statusWords["en"][state]; ok {
+		return word
+	}
+
+	return state
+}
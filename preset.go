@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"text/template"
+
+	"github.com/daviddengcn/go-colortext"
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+const (
+	escapeNone = ""
+	escapeZsh  = "zsh"
+	escapeBash = "bash"
+	escapeTmux = "tmux"
+)
+
+// preset is a named output format: a template rendered with the fields of
+// presetData, how color codes are escaped for the program embedding the
+// output, and whether to color at all.
+type preset struct {
+	template string
+	escape   string
+	color    bool
+}
+
+type presetData struct {
+	// Mark is the mark and any suffix, colored and escaped
+	Mark string
+	// Plain is the mark and any suffix without color
+	Plain  string
+	Status string
+	// Word is the status in the language of the user
+	Word string
+}
+
+var builtinPresets = map[string]preset{
+	escapeZsh:  {"{{.Mark}}", escapeZsh, true},
+	escapeBash: {"{{.Mark}}", escapeBash, true},
+	escapeTmux: {"{{.Mark}}", escapeTmux, true},
+}
+
+// loadPreset returns the preset name, defined by
+// github-commit-status-preset.<name>.template, .escape and .color in git
+// config, each falling back to the built-in preset of the same name.
+func loadPreset(name string) (preset, error) {
+	p, ok := builtinPresets[name]
+	if !ok {
+		p = preset{template: "{{.Mark}}", color: true}
+	}
+
+	section := "github-commit-status-preset." + name + "."
+
+	t := statusmark.GitConfig("--get", section+"template")
+	e := statusmark.GitConfig("--get", section+"escape")
+	c := statusmark.GitConfig("--get", section+"color")
+	if !ok && t == "" && e == "" && c == "" {
+		return p, fmt.Errorf("no such preset: %s", name)
+	}
+
+	if t != "" {
+		p.template = t
+	}
+	if e != "" {
+		p.escape = e
+	}
+	if c != "" {
+		color, err := strconv.ParseBool(c)
+		if err != nil {
+			return p, fmt.Errorf("%scolor: %s", section, err)
+		}
+		p.color = color
+	}
+
+	return p, nil
+}
+
+// outputPreset returns the preset name, if given, with color codes escaped
+// as escape says, if given, or nil for the plain mark.
+func outputPreset(name, escape string) (*preset, error) {
+	if name == "" && escape == "" {
+		return nil, nil
+	}
+
+	p := preset{template: "{{.Mark}}", color: true}
+	if name != "" {
+		var err error
+		if p, err = loadPreset(name); err != nil {
+			return nil, err
+		}
+	}
+
+	switch escape {
+	case escapeNone:
+	case escapeZsh, escapeBash:
+		p.escape = escape
+	default:
+		return nil, fmt.Errorf("-escape: must be zsh or bash: %s", escape)
+	}
+
+	return &p, nil
+}
+
+var tmuxColorNames = map[ct.Color]string{
+	ct.Black:   "black",
+	ct.Red:     "red",
+	ct.Green:   "green",
+	ct.Yellow:  "yellow",
+	ct.Blue:    "blue",
+	ct.Magenta: "magenta",
+	ct.Cyan:    "cyan",
+	ct.White:   "white",
+}
+
+// colorize wraps s in the color codes for c, escaped for the given program.
+func colorize(s string, c ct.Color, escape string) string {
+	if c == ct.None {
+		return s
+	}
+
+	if escape == escapeTmux {
+		return fmt.Sprintf("#[fg=%s]%s#[default]", tmuxColorNames[c], s)
+	}
+
+	start := fmt.Sprintf("\x1b[%dm", 30+int(c-ct.Black))
+	reset := "\x1b[0m"
+
+	switch escape {
+	case escapeZsh:
+		start, reset = "%{"+start+"%}", "%{"+reset+"%}"
+	case escapeBash:
+		start, reset = `\[`+start+`\]`, `\[`+reset+`\]`
+	}
+
+	return start + s + reset
+}
+
+func (p preset) render(status, suffix string) (string, error) {
+	tmpl, err := template.New("preset").Parse(p.template)
+	if err != nil {
+		return "", err
+	}
+
+	conf, ok := statusConfiguration[status]
+	if !ok {
+		conf = statusConfiguration[statusmark.StatusUnknown]
+	}
+
+	data := presetData{
+		Mark:   conf.mark + suffix,
+		Plain:  conf.mark + suffix,
+		Status: status,
+		Word:   statusWord(status),
+	}
+	// Presets are for programs other than the terminal, so only never, as
+	// from -color, NO_COLOR or the settings, turns their color off
+	if p.color && colorUI() != colorNever {
+		data.Mark = colorize(data.Plain, conf.color, p.escape)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
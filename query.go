@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A query is a small subset of jq: stages separated by "|", each one of
+//
+//	.            the input itself
+//	.a.b         a field
+//	.a[] .[]     every element of an array or object
+//	.a[0]        an element of an array
+//	select(.a == "x")  the input if the comparison (== or !=) holds
+//	length       the length of an array, object or string
+//
+// Every stage is applied to each output of the previous one.
+type queryStage func(v interface{}) ([]interface{}, error)
+
+type pathStep struct {
+	key     string
+	index   int
+	isIndex bool
+	iterate bool
+}
+
+// splitOutsideQuotes splits s at sep where it is not inside a string literal
+// or parentheses.
+func splitOutsideQuotes(s string, sep string) []string {
+	var (
+		parts   []string
+		start   int
+		quoted  bool
+		escaped bool
+		depth   int
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+func parsePath(expr string) ([]pathStep, error) {
+	if !strings.HasPrefix(expr, ".") {
+		return nil, fmt.Errorf("path must start with '.': %s", expr)
+	}
+
+	var steps []pathStep
+	s := expr[1:]
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, "[]"):
+			steps = append(steps, pathStep{iterate: true})
+			s = s[2:]
+
+		case s[0] == '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed '[' in %s", expr)
+			}
+			n, err := strconv.Atoi(s[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("bad index in %s", expr)
+			}
+			steps = append(steps, pathStep{index: n, isIndex: true})
+			s = s[end+1:]
+
+		case s[0] == '.':
+			s = s[1:]
+
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			steps = append(steps, pathStep{key: s[:end]})
+			s = s[end:]
+		}
+	}
+
+	return steps, nil
+}
+
+func evalPath(steps []pathStep, v interface{}) ([]interface{}, error) {
+	values := []interface{}{v}
+	for _, step := range steps {
+		var next []interface{}
+		for _, v := range values {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				if step.isIndex {
+					return nil, fmt.Errorf("cannot index object with number")
+				}
+				if step.iterate {
+					for _, e := range v {
+						next = append(next, e)
+					}
+				} else {
+					next = append(next, v[step.key])
+				}
+
+			case []interface{}:
+				if step.iterate {
+					next = append(next, v...)
+				} else if step.isIndex {
+					if step.index < 0 || step.index >= len(v) {
+						next = append(next, nil)
+					} else {
+						next = append(next, v[step.index])
+					}
+				} else {
+					return nil, fmt.Errorf("cannot index array with %q", step.key)
+				}
+
+			case nil:
+				next = append(next, nil)
+
+			default:
+				return nil, fmt.Errorf("cannot index %v", v)
+			}
+		}
+		values = next
+	}
+
+	return values, nil
+}
+
+func parseSelect(cond string) (queryStage, error) {
+	op := "=="
+	sides := splitOutsideQuotes(cond, op)
+	if len(sides) != 2 {
+		op = "!="
+		sides = splitOutsideQuotes(cond, op)
+	}
+	if len(sides) != 2 {
+		return nil, fmt.Errorf("select needs a comparison with == or !=: %s", cond)
+	}
+
+	steps, err := parsePath(strings.TrimSpace(sides[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	var want interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(sides[1])), &want); err != nil {
+		return nil, fmt.Errorf("bad literal in select: %s", sides[1])
+	}
+
+	return func(v interface{}) ([]interface{}, error) {
+		got, err := evalPath(steps, v)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range got {
+			if reflect.DeepEqual(g, want) == (op == "==") {
+				return []interface{}{v}, nil
+			}
+		}
+		return nil, nil
+	}, nil
+}
+
+func parseQuery(expr string) ([]queryStage, error) {
+	var stages []queryStage
+	for _, s := range splitOutsideQuotes(expr, "|") {
+		s = strings.TrimSpace(s)
+
+		switch {
+		case s == "length":
+			stages = append(stages, func(v interface{}) ([]interface{}, error) {
+				switch v := v.(type) {
+				case []interface{}:
+					return []interface{}{len(v)}, nil
+				case map[string]interface{}:
+					return []interface{}{len(v)}, nil
+				case string:
+					return []interface{}{len([]rune(v))}, nil
+				case nil:
+					return []interface{}{0}, nil
+				}
+				return nil, fmt.Errorf("%v has no length", v)
+			})
+
+		case strings.HasPrefix(s, "select(") && strings.HasSuffix(s, ")"):
+			stage, err := parseSelect(s[len("select(") : len(s)-1])
+			if err != nil {
+				return nil, err
+			}
+			stages = append(stages, stage)
+
+		default:
+			steps, err := parsePath(s)
+			if err != nil {
+				return nil, err
+			}
+			stages = append(stages, func(v interface{}) ([]interface{}, error) {
+				return evalPath(steps, v)
+			})
+		}
+	}
+
+	return stages, nil
+}
+
+// runQuery applies expr to the JSON representation of doc.
+func runQuery(expr string, doc interface{}) ([]interface{}, error) {
+	stages, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return nil, err
+	}
+
+	values := []interface{}{v}
+	for _, stage := range stages {
+		var next []interface{}
+		for _, v := range values {
+			out, err := stage(v)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, out...)
+		}
+		values = next
+	}
+
+	return values, nil
+}
+
+// printQuery prints each value selected by expr on its own line; strings
+// are printed as they are, like jq -r, and other values as JSON.
+func printQuery(expr string, doc interface{}) error {
+	values, err := runQuery(expr, doc)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			fmt.Println(s)
+			continue
+		}
+
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buf))
+	}
+
+	return nil
+}
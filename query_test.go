@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunQuery(t *testing.T) {
+	doc := map[string]interface{}{
+		"status": "success",
+		"sha":    "0123456789abcdef",
+		"contexts": []map[string]interface{}{
+			{"context": "ci/build", "state": "success"},
+			{"context": "ci/lint", "state": "failure"},
+			{"context": "a|b", "state": "pending"},
+		},
+		"empty": nil,
+	}
+
+	tests := []struct {
+		expr string
+		want []interface{}
+	}{
+		// paths
+		{".", []interface{}{map[string]interface{}{
+			"status": "success",
+			"sha":    "0123456789abcdef",
+			"contexts": []interface{}{
+				map[string]interface{}{"context": "ci/build", "state": "success"},
+				map[string]interface{}{"context": "ci/lint", "state": "failure"},
+				map[string]interface{}{"context": "a|b", "state": "pending"},
+			},
+			"empty": nil,
+		}}},
+		{".status", []interface{}{"success"}},
+		{".missing", []interface{}{nil}},
+		{".empty.a", []interface{}{nil}},
+		{".contexts[].context", []interface{}{"ci/build", "ci/lint", "a|b"}},
+		{".contexts | .[] | .state", []interface{}{"success", "failure", "pending"}},
+		// indexes
+		{".contexts[0].context", []interface{}{"ci/build"}},
+		{".contexts[2].state", []interface{}{"pending"}},
+		{".contexts[3]", []interface{}{nil}},
+		{".contexts[-1]", []interface{}{nil}},
+		// select
+		{`.contexts[] | select(.state == "failure") | .context`, []interface{}{"ci/lint"}},
+		{`.contexts[] | select(.state != "success") | .context`, []interface{}{"ci/lint", "a|b"}},
+		{`.contexts[] | select(.context == "a|b") | .state`, []interface{}{"pending"}},
+		{`.contexts[] | select(.state == "error")`, nil},
+		{`select(.empty == null) | .status`, []interface{}{"success"}},
+		// length
+		{".contexts | length", []interface{}{3}},
+		{".sha | length", []interface{}{16}},
+		{".empty | length", []interface{}{0}},
+		{".contexts[0] | length", []interface{}{2}},
+		{`.contexts[] | select(.state == "success") | .context | length`, []interface{}{8}},
+	}
+
+	for _, test := range tests {
+		got, err := runQuery(test.expr, doc)
+		if err != nil {
+			t.Errorf("runQuery(%q): %s", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("runQuery(%q) = %#v, want %#v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestRunQueryErrors(t *testing.T) {
+	doc := map[string]interface{}{
+		"status":   "success",
+		"count":    1,
+		"contexts": []interface{}{"ci/build"},
+	}
+
+	for _, expr := range []string{
+		// malformed
+		"",
+		"status",
+		".contexts[",
+		".contexts[x]",
+		"select(.status)",
+		"select(.status == success)",
+		`select(status == "success")`,
+		".status | nosuch",
+		// not applicable to the input
+		".status.a",
+		".contexts.a",
+		".status[0]",
+		".[0]",
+		".count | length",
+	} {
+		if got, err := runQuery(expr, doc); err == nil {
+			t.Errorf("runQuery(%q) = %#v, want an error", expr, got)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// serviceName names the systemd unit and the launchd job of the daemon.
+const serviceName = "github-commit-status-mark"
+
+// launchdLabel is the label of the launchd job of the daemon.
+const launchdLabel = "com.github.motemen." + serviceName
+
+// serviceEnv returns the environment the daemon is to run with: PATH, for
+// git, and the settings given in the environment of this process, but for
+// tokens, which do not belong in service files.
+func serviceEnv() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+
+		name := pair[0]
+		switch {
+		case name == "PATH", strings.HasPrefix(name, "XDG_") && name != "XDG_RUNTIME_DIR":
+		case strings.HasPrefix(name, "GCSM_"), strings.HasPrefix(name, "GITHUB_COMMIT_STATUS_MARK_"):
+			if strings.Contains(name, "TOKEN") {
+				continue
+			}
+		default:
+			continue
+		}
+		env[name] = pair[1]
+	}
+
+	return env
+}
+
+// sortedKeys returns the keys of m in order, so that service files come out
+// the same every time.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// systemdQuote quotes s for a systemd unit file, where % starts a
+// specifier.
+func systemdQuote(s string) string {
+	s = strings.Replace(s, "%", "%%", -1)
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// systemdUnit returns the systemd user unit running the daemon at socket.
+func systemdUnit(executable, socket string, env map[string]string) string {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "[Unit]")
+	fmt.Fprintln(&buf, "Description=github-commit-status-mark daemon")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "[Service]")
+	fmt.Fprintf(&buf, "ExecStart=%s -daemon -socket %s\n", systemdQuote(executable), systemdQuote(socket))
+	fmt.Fprintln(&buf, "ExecReload=/bin/kill -HUP $MAINPID")
+	for _, name := range sortedKeys(env) {
+		fmt.Fprintf(&buf, "Environment=%s\n", systemdQuote(name+"="+env[name]))
+	}
+	fmt.Fprintln(&buf, "Restart=on-failure")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "[Install]")
+	fmt.Fprintln(&buf, "WantedBy=default.target")
+
+	return buf.String()
+}
+
+// launchdPlist returns the launchd agent running the daemon at socket.
+func launchdPlist(executable, socket string, env map[string]string) string {
+	str := func(s string) string {
+		var buf bytes.Buffer
+		xml.EscapeText(&buf, []byte(s))
+		return "<string>" + buf.String() + "</string>"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(&buf, `<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">`)
+	fmt.Fprintln(&buf, `<plist version="1.0">`)
+	fmt.Fprintln(&buf, `<dict>`)
+	fmt.Fprintf(&buf, "\t<key>Label</key>\n\t%s\n", str(launchdLabel))
+	fmt.Fprintln(&buf, "\t<key>ProgramArguments</key>")
+	fmt.Fprintln(&buf, "\t<array>")
+	for _, arg := range []string{executable, "-daemon", "-socket", socket} {
+		fmt.Fprintf(&buf, "\t\t%s\n", str(arg))
+	}
+	fmt.Fprintln(&buf, "\t</array>")
+	fmt.Fprintln(&buf, "\t<key>EnvironmentVariables</key>")
+	fmt.Fprintln(&buf, "\t<dict>")
+	for _, name := range sortedKeys(env) {
+		fmt.Fprintf(&buf, "\t\t<key>%s</key>\n\t\t%s\n", name, str(env[name]))
+	}
+	fmt.Fprintln(&buf, "\t</dict>")
+	fmt.Fprintln(&buf, "\t<key>RunAtLoad</key>\n\t<true/>")
+	fmt.Fprintln(&buf, "\t<key>KeepAlive</key>\n\t<true/>")
+	fmt.Fprintln(&buf, `</dict>`)
+	fmt.Fprintln(&buf, `</plist>`)
+
+	return buf.String()
+}
+
+// runInstallService writes the systemd user unit, or the launchd agent on
+// macOS, running the daemon at socket with this executable and the
+// environment it needs, and enables and starts it. With -print, the file is
+// printed instead.
+func runInstallService(args []string, socket string) {
+	flags := flag.NewFlagSet("daemon install", flag.ExitOnError)
+	printOnly := flags.Bool("print", false, "Print the service file instead of installing it")
+	flags.Parse(args)
+
+	executable, err := os.Executable()
+	dieIf(err)
+	executable, err = filepath.EvalSymlinks(executable)
+	dieIf(err)
+	socket, err = filepath.Abs(socket)
+	dieIf(err)
+
+	var path, content string
+	var commands [][]string
+	if runtime.GOOS == "darwin" {
+		home, err := os.UserHomeDir()
+		dieIf(err)
+		path = filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist")
+		content = launchdPlist(executable, socket, serviceEnv())
+		commands = [][]string{
+			{"launchctl", "unload", path},
+			{"launchctl", "load", "-w", path},
+		}
+	} else {
+		dir, err := os.UserConfigDir()
+		dieIf(err)
+		path = filepath.Join(dir, "systemd", "user", serviceName+".service")
+		content = systemdUnit(executable, socket, serviceEnv())
+		commands = [][]string{
+			{"systemctl", "--user", "daemon-reload"},
+			{"systemctl", "--user", "enable", "--now", serviceName + ".service"},
+			// To pick up a new executable, socket or environment
+			{"systemctl", "--user", "restart", serviceName + ".service"},
+		}
+	}
+
+	if *printOnly {
+		fmt.Print(content)
+		return
+	}
+
+	dieIf(os.MkdirAll(filepath.Dir(path), 0755))
+	dieIf(ioutil.WriteFile(path, []byte(content), 0644))
+	fmt.Printf("Wrote %s\n", path)
+
+	for i, command := range commands {
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		// Unloading a job that is not loaded yet fails, which is fine
+		if err := cmd.Run(); err != nil && !(runtime.GOOS == "darwin" && i == 0) {
+			die(fmt.Sprintf("%s: %s", strings.Join(command, " "), err))
+		}
+	}
+	fmt.Printf("The daemon listens on %s; point GCSM_SOCKET or -socket there\n", socket)
+}
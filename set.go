@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/google/go-github/github"
+	"github.com/motemen/github-commit-status-mark/statusmark"
+	"gopkg.in/yaml.v2"
+)
+
+type manifestStatus struct {
+	Context     string `yaml:"context"`
+	State       string `yaml:"state"`
+	Description string `yaml:"description"`
+	TargetURL   string `yaml:"target_url"`
+}
+
+// statusManifest describes statuses to post at once. Being YAML, it may be
+// written as JSON as well.
+type statusManifest struct {
+	Statuses []manifestStatus `yaml:"statuses"`
+}
+
+func readManifest(path string) (*statusManifest, error) {
+	var (
+		buf []byte
+		err error
+	)
+	if path == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest statusManifest
+	if err := yaml.Unmarshal(buf, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return &manifest, nil
+}
+
+// runSet posts every status in a manifest to the target revision
+// concurrently, reporting each failure and exiting non-zero if any failed.
+func runSet(repo statusmark.Repository, args []string, dryRun bool) {
+	flags := flag.NewFlagSet("set", flag.ExitOnError)
+	var (
+		manifestPath = flags.String("f", "", "Read statuses to post from `manifest` (YAML or JSON, - for stdin)")
+		concurrency  = flags.Int("concurrency", 4, "Number of statuses to post at a time")
+	)
+	flags.Parse(args)
+
+	if *manifestPath == "" {
+		die("usage: github-commit-status-mark set -f <manifest> [<rev>]")
+	}
+
+	manifest, err := readManifest(*manifestPath)
+	dieIf(err)
+
+	_, rev, err := repo.Resolve(targetRevision(flags.Args()))
+	dieIf(err)
+
+	state, err := statusmark.NewCache(repo)
+	dieIf(err)
+	dieIf(state.Restore())
+
+	remote, err := statusmark.ParseRemote(repo, statusmark.ConfiguredRemotes(repo)[0])
+	dieIf(err)
+	requireGitHub(remote, "set")
+	// Counted apart, to be added to the cache read again under its lock
+	var apiCalls int64
+	client := statusmark.NewAPIClient(remote.URL, statusmark.ClientOptions{
+		RetryPolicy: statusmark.LoadRetryPolicy(statusmark.RetryModePrompt),
+		APICalls:    &apiCalls,
+		DryRun:      dryRun,
+	})
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+		sem    = make(chan struct{}, *concurrency)
+	)
+	for _, s := range manifest.Statuses {
+		wg.Add(1)
+		go func(s manifestStatus) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			status := &github.RepoStatus{
+				State:   github.String(s.State),
+				Context: github.String(s.Context),
+			}
+			if s.Description != "" {
+				status.Description = github.String(s.Description)
+			}
+			if s.TargetURL != "" {
+				status.TargetURL = github.String(s.TargetURL)
+			}
+
+			_, _, err := client.Repositories.CreateStatus(remote.Owner, remote.Name, rev, status)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: %s\n", s.Context, err)
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	if dryRun {
+		return
+	}
+
+	// The posted statuses make whatever was cached for rev obsolete
+	dieIf(state.Update(context.Background(), func() error {
+		state.Stats.APICalls += apiCalls
+		delete(state.Revisions, rev)
+		return nil
+	}))
+
+	if failed > 0 {
+		die(fmt.Sprintf("%d of %d statuses could not be posted", failed, len(manifest.Statuses)))
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+// lispString quotes s as an Emacs Lisp string literal.
+func lispString(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+// lispStringOrNil returns s quoted as a string, or nil if it is empty.
+func lispStringOrNil(s string) string {
+	if s == "" {
+		return "nil"
+	}
+	return lispString(s)
+}
+
+// printSexp prints entry as a plist for Emacs, e.g.
+//
+//	(:revision "0123abc" :status "failure" :mark "✗" :cached t :contexts ((:context "ci/test" :state "failure")))
+//
+// :status is nil when the status is unknown.
+func printSexp(entry statusmark.Entry, rev string, cached bool) {
+	conf, ok := statusConfiguration[entry.Status]
+	if !ok {
+		conf = statusConfiguration[statusmark.StatusUnknown]
+	}
+
+	cachedValue := "nil"
+	if cached {
+		cachedValue = "t"
+	}
+
+	fields := []string{
+		":revision", lispString(rev),
+		":status", lispStringOrNil(entry.Status),
+		":mark", lispString(conf.mark),
+		":rule", lispStringOrNil(entry.Rule),
+		":cached", cachedValue,
+	}
+
+	contexts := make([]string, len(entry.Contexts))
+	for i, c := range entry.Contexts {
+		contexts[i] = fmt.Sprintf("(:context %s :state %s :description %s :target-url %s)",
+			lispString(c.Context), lispString(c.State), lispStringOrNil(c.Description), lispStringOrNil(c.TargetURL))
+	}
+	fields = append(fields, ":contexts", "("+strings.Join(contexts, " ")+")")
+
+	fmt.Printf("(%s)\n", strings.Join(fields, " "))
+}
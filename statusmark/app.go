@@ -0,0 +1,182 @@
+package statusmark
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	appTokenSources   = map[string]oauth2.TokenSource{}
+	appTokenSourcesMu sync.Mutex
+)
+
+// appTokenSource returns the source of installation tokens of the GitHub
+// App set for remoteURL in github-commit-status.<url>.appID,
+// .appInstallationID and .appPrivateKey (the path to its PEM file), or nil
+// if none is. Incomplete settings give a source failing with why.
+// Installation tokens last an hour; the source is kept so that a new one
+// is obtained only when the last has expired.
+func appTokenSource(remoteURL *url.URL) oauth2.TokenSource {
+	appID := configURLValue("appID", remoteURL)
+	if appID == "" {
+		return nil
+	}
+
+	appTokenSourcesMu.Lock()
+	defer appTokenSourcesMu.Unlock()
+
+	if s, ok := appTokenSources[remoteURL.String()]; ok {
+		return s
+	}
+
+	installationID := configURLValue("appInstallationID", remoteURL)
+	if installationID == "" {
+		return brokenTokenSource{errors.New("github-commit-status.appInstallationID must be set along with appID")}
+	}
+
+	keyFile := configURLValue("appPrivateKey", remoteURL)
+	if keyFile == "" {
+		return brokenTokenSource{errors.New("github-commit-status.appPrivateKey must be set along with appID")}
+	}
+	key, err := readPrivateKey(keyFile)
+	if err != nil {
+		return brokenTokenSource{err}
+	}
+
+	s := oauth2.ReuseTokenSource(nil, installationTokenSource{
+		appID:          appID,
+		installationID: installationID,
+		key:            key,
+		url:            remoteURL,
+	})
+	appTokenSources[remoteURL.String()] = s
+
+	return s
+}
+
+// brokenTokenSource fails with err, for an app whose settings are
+// incomplete, so that requests needing its tokens report why.
+type brokenTokenSource struct {
+	err error
+}
+
+func (s brokenTokenSource) Token() (*oauth2.Token, error) {
+	return nil, s.err
+}
+
+// readPrivateKey reads an RSA private key in PKCS #1 (as GitHub generates
+// them) or PKCS #8 PEM.
+func readPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA private key", path)
+	}
+
+	return rsaKey, nil
+}
+
+// installationTokenSource obtains installation tokens of a GitHub App,
+// authenticating as the app with a JWT signed by its private key.
+type installationTokenSource struct {
+	appID          string
+	installationID string
+	key            *rsa.PrivateKey
+	url            *url.URL
+}
+
+// jwt returns a JWT of the app valid for a few minutes, backdated a little
+// to allow for clock drift.
+func (s installationTokenSource) jwt() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"iss": s.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func (s installationTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := apiBaseURL(s.url)
+	if err != nil {
+		return nil, err
+	}
+
+	u := base.ResolveReference(&url.URL{Path: fmt.Sprintf("app/installations/%s/access_tokens", s.installationID)})
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := (&http.Client{Transport: sharedTransport(s.url)}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("GitHub App installation token: %s", resp.Status)
+	}
+
+	var body struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Token{AccessToken: body.Token, Expiry: body.ExpiresAt}, nil
+}
@@ -0,0 +1,83 @@
+package statusmark
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// bitbucket reads the build statuses of Bitbucket Cloud.
+type bitbucket struct{}
+
+// bitbucketStates maps the states of Bitbucket builds onto the states marks
+// are configured for.
+var bitbucketStates = map[string]string{
+	"INPROGRESS": StatusInProgress,
+	"SUCCESSFUL": StatusSuccess,
+	"FAILED":     StatusFailure,
+	// Like cancelled check runs
+	"STOPPED": StatusFailure,
+}
+
+func (bitbucket) apiBase(remoteURL *url.URL) *url.URL {
+	return &url.URL{Scheme: "https", Host: "api." + remoteURL.Host, Path: "/2.0/"}
+}
+
+// basicAuth returns the app password of BITBUCKET_USERNAME and
+// BITBUCKET_APP_PASSWORD, or else of the login in ~/.netrc for the API host
+// or bitbucket.org, where git may have it already.
+func (bitbucket) basicAuth(remoteURL *url.URL) *url.Userinfo {
+	if user, password := os.Getenv("BITBUCKET_USERNAME"), os.Getenv("BITBUCKET_APP_PASSWORD"); user != "" && password != "" {
+		return url.UserPassword(user, password)
+	}
+
+	for _, host := range []string{"api." + remoteURL.Host, remoteURL.Host} {
+		if machine, _ := netrcMachine(host); machine != nil && machine.Login != "" {
+			return url.UserPassword(machine.Login, machine.Password)
+		}
+	}
+
+	return nil
+}
+
+// contexts lists the statuses of the commit, one per key. Those are named
+// by their names, as the keys tend to be opaque.
+func (bitbucket) contexts(client *http.Client, base *url.URL, remote Remote, sha string) ([]ContextStatus, error) {
+	var page struct {
+		Values []struct {
+			Key         string    `json:"key"`
+			Name        string    `json:"name"`
+			State       string    `json:"state"`
+			Description string    `json:"description"`
+			URL         string    `json:"url"`
+			UpdatedOn   time.Time `json:"updated_on"`
+		} `json:"values"`
+	}
+	u := base.String() + "repositories/" + url.PathEscape(remote.Owner) + "/" + url.PathEscape(remote.Name) + "/commit/" + sha + "/statuses?pagelen=100"
+	if err := getJSON(client, u, &page); err != nil {
+		return nil, err
+	}
+
+	contexts := []ContextStatus{}
+	for _, s := range page.Values {
+		state, ok := bitbucketStates[s.State]
+		if !ok {
+			state = StatusUnknown
+		}
+		name := s.Name
+		if name == "" {
+			name = s.Key
+		}
+
+		contexts = append(contexts, ContextStatus{
+			Context:     name,
+			State:       state,
+			Description: s.Description,
+			TargetURL:   s.URL,
+			UpdatedAt:   s.UpdatedOn,
+		})
+	}
+
+	return contexts, nil
+}
@@ -0,0 +1,287 @@
+package statusmark
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// cacheVersion is the format of the cache file, bumped whenever it changes
+// incompatibly so that caches written before are started over.
+const cacheVersion = 1
+
+type Cache struct {
+	Version   int
+	Revisions map[string]Entry
+	Stats     CacheStats
+	Hosts     map[string]HostEntry
+	Upstreams map[string]string
+	// Protections are the required contexts by host/owner/name/branch
+	Protections map[string]ProtectionEntry
+	// RateLimits are the API budgets by host
+	RateLimits map[string]RateLimit
+	// APIBases are the API roots probed by host
+	APIBases map[string]string `json:",omitempty"`
+	path     string
+	// saved is the cache as last read from or written to path
+	saved []byte
+	// store, if set, keeps the cache in SQLite rather than at path
+	store *sqliteStore
+	// hits and used are what RecordHit and touch recorded since the last
+	// save, by key for the times of use
+	hits int
+	used map[string]int64
+}
+
+type Entry struct {
+	Status       string
+	Contexts     []ContextStatus
+	Rule         string
+	LastModified int64
+	// ETags are those of the responses the entry was made from, by URL
+	ETags map[string]string `json:",omitempty"`
+	// LastUsed is when the entry was last looked up, in Unix time
+	LastUsed int64 `json:",omitempty"`
+}
+
+// lastUsed returns when entry was last fetched or looked up.
+func (entry Entry) lastUsed() int64 {
+	if entry.LastUsed > entry.LastModified {
+		return entry.LastUsed
+	}
+
+	return entry.LastModified
+}
+
+// Path returns where the cache is stored.
+func (state *Cache) Path() string {
+	if state.store != nil {
+		return state.store.path
+	}
+
+	return state.path
+}
+
+// Restore reads the cache file into state. A file that is broken or of
+// another version is ignored, to be replaced on Save.
+func (state *Cache) Restore() error {
+	if state.store != nil {
+		return state.store.restore(state)
+	}
+
+	buf, err := ioutil.ReadFile(state.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var probe Cache
+	if err := json.Unmarshal(buf, &probe); err != nil || probe.Version != cacheVersion {
+		return nil
+	}
+
+	state.saved = bytes.TrimSpace(buf)
+	return json.Unmarshal(buf, state)
+}
+
+// Save writes state to a temporary file renamed over the cache file, so
+// that the file is never seen half written, even if the process dies. The
+// file is left alone if nothing changed since it was read or written.
+func (state *Cache) Save() error {
+	cacheDir, _ := filepath.Split(state.path)
+
+	err := os.MkdirAll(cacheDir, 0777)
+	if err != nil {
+		return err
+	}
+
+	state.addRecorded()
+	state.Prune()
+
+	if state.store != nil {
+		return state.store.save(state)
+	}
+
+	state.Version = cacheVersion
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(buf, state.saved) {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(cacheDir, "cache.*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(buf, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), state.path); err != nil {
+		return err
+	}
+
+	state.saved = buf
+	return nil
+}
+
+// CacheDir is -cache-dir, taking precedence over any setting.
+var CacheDir string
+
+// cacheRoot returns where the caches of repositories are kept: -cache-dir,
+// github-commit-status.cacheDir, or github-commit-status-mark in the
+// user's cache directory ($XDG_CACHE_HOME, or ~/.cache on Linux).
+func cacheRoot() string {
+	if CacheDir != "" {
+		return CacheDir
+	}
+	if dir := ConfigValue("cacheDir"); dir != "" {
+		return dir
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "github-commit-status-mark")
+}
+
+// repoKey names the cache of repo by host/owner/name of its first
+// configured remote, so that the clones, worktrees and mirrors of a
+// repository share the entries of its commits. A repository without one is
+// named after its main worktree, for people to find, and a hash of its
+// common git directory, to tell apart those of the same name while sharing
+// the cache between its linked worktrees.
+func repoKey(repo Repository) (string, error) {
+	if remote, err := ParseRemote(repo, ConfiguredRemotes(repo)[0]); err == nil {
+		return path.Join("repos", remote.URL.Host, remote.Owner, strings.TrimSuffix(remote.Name, ".git")), nil
+	}
+
+	common, err := repo.CommonDir()
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(common)
+	if name == ".git" {
+		name = filepath.Base(filepath.Dir(common))
+	}
+	sum := sha256.Sum256([]byte(common))
+	return path.Join("local", strings.TrimSuffix(name, ".git")+"-"+hex.EncodeToString(sum[:])[:12]), nil
+}
+
+// NewCache returns the cache of repo, kept out of its work tree so that
+// read-only checkouts work and nothing needs ignoring: in a file of its
+// own, or in the SQLite database shared by all repositories if
+// github-commit-status.cacheBackend is "sqlite", in builds with the sqlite
+// tag.
+func NewCache(repo Repository) (*Cache, error) {
+	key, err := repoKey(repo)
+	if err != nil {
+		return nil, err
+	}
+	state := &Cache{
+		path: filepath.Join(cacheRoot(), filepath.FromSlash(key), "cache"),
+	}
+
+	switch backend := ConfigValue("cacheBackend"); backend {
+	case "", "json":
+	case "sqlite":
+		if state.store, err = newSQLiteStore(filepath.Join(cacheRoot(), "cache.db"), key); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown cache backend: %s", backend)
+	}
+
+	return state, nil
+}
+
+// Fork returns a cache starting with what state knows of hosts and
+// upstreams, for looking up revisions concurrently. Merge its entries back
+// when done.
+func (state *Cache) Fork() *Cache {
+	fork := &Cache{
+		Revisions:   map[string]Entry{},
+		Hosts:       map[string]HostEntry{},
+		Upstreams:   map[string]string{},
+		Protections: map[string]ProtectionEntry{},
+		RateLimits:  map[string]RateLimit{},
+		path:        state.path,
+	}
+	for k, v := range state.Hosts {
+		fork.Hosts[k] = v
+	}
+	for k, v := range state.Upstreams {
+		fork.Upstreams[k] = v
+	}
+	for k, v := range state.Protections {
+		fork.Protections[k] = v
+	}
+	for k, v := range state.RateLimits {
+		fork.RateLimits[k] = v
+	}
+
+	return fork
+}
+
+// Merge adds the entries and stats of fork to state.
+func (state *Cache) Merge(fork *Cache) {
+	if state.Revisions == nil {
+		state.Revisions = map[string]Entry{}
+	}
+	if state.Hosts == nil {
+		state.Hosts = map[string]HostEntry{}
+	}
+	if state.Upstreams == nil {
+		state.Upstreams = map[string]string{}
+	}
+	if state.Protections == nil {
+		state.Protections = map[string]ProtectionEntry{}
+	}
+
+	for k, v := range fork.Revisions {
+		state.Revisions[k] = v
+	}
+	for k, v := range fork.Hosts {
+		state.Hosts[k] = v
+	}
+	for k, v := range fork.Upstreams {
+		state.Upstreams[k] = v
+	}
+	for k, v := range fork.Protections {
+		state.Protections[k] = v
+	}
+	// The budget left is the least any fork saw
+	for k, v := range fork.RateLimits {
+		if cur, ok := state.rateLimits()[k]; !ok || v.Reset > cur.Reset || v.Reset == cur.Reset && v.Remaining < cur.Remaining {
+			state.RateLimits[k] = v
+		}
+	}
+
+	state.Stats.Hits += fork.Stats.Hits
+	state.Stats.Misses += fork.Stats.Misses
+	state.Stats.APICalls += fork.Stats.APICalls
+	state.Stats.Fetches += fork.Stats.Fetches
+	state.Stats.FetchTime += fork.Stats.FetchTime
+	if fork.Stats.MaxFetchTime > state.Stats.MaxFetchTime {
+		state.Stats.MaxFetchTime = fork.Stats.MaxFetchTime
+	}
+}
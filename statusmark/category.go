@@ -0,0 +1,46 @@
+package statusmark
+
+import "strings"
+
+type Category struct {
+	Label    string
+	Patterns []string
+}
+
+// ConfiguredCategories parses github-commit-status.categories, a
+// space-separated list of label=glob pairs such as
+// "B=ci/build* T=ci/test* T=e2e/* L=lint". Repeating a label adds another
+// glob to it; labels keep the order they first appear in.
+func ConfiguredCategories() []Category {
+	var categories []Category
+	index := map[string]int{}
+
+	for _, pair := range strings.Fields(ConfigValue("categories")) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		i, ok := index[kv[0]]
+		if !ok {
+			i = len(categories)
+			index[kv[0]] = i
+			categories = append(categories, Category{Label: kv[0]})
+		}
+		categories[i].Patterns = append(categories[i].Patterns, kv[1])
+	}
+
+	return categories
+}
+
+// CategoryStatus rolls up the contexts belonging to c.
+func CategoryStatus(c Category, contexts []ContextStatus) string {
+	var matched []ContextStatus
+	for _, ctx := range contexts {
+		if MatchContext(ctx.Context, c.Patterns) {
+			matched = append(matched, ctx)
+		}
+	}
+
+	return rollupContexts(matched, WarningContextPatterns())
+}
@@ -0,0 +1,129 @@
+package statusmark
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	checkRunQueued     = "queued"
+	checkRunInProgress = "in_progress"
+	checkRunCompleted  = "completed"
+)
+
+// defaultConclusionStates maps terminal check run conclusions onto the
+// states marks are configured for.
+var defaultConclusionStates = map[string]string{
+	"success":         StatusSuccess,
+	"failure":         StatusFailure,
+	"action_required": StatusActionRequired,
+	"neutral":         StatusSuccess,
+	"skipped":         StatusSuccess,
+	"cancelled":       StatusFailure,
+	"timed_out":       StatusFailure,
+	"startup_failure": StatusError,
+	"stale":           StatusUnknown,
+}
+
+// ConclusionStates returns defaultConclusionStates overridden by
+// github-commit-status.conclusions, a space-separated list of
+// conclusion:state pairs such as "cancelled:failure skipped:success".
+func ConclusionStates() map[string]string {
+	states := map[string]string{}
+	for conclusion, state := range defaultConclusionStates {
+		states[conclusion] = state
+	}
+
+	for _, pair := range strings.Fields(ConfigValue("conclusions")) {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) == 2 {
+			states[kv[0]] = kv[1]
+		}
+	}
+
+	return states
+}
+
+// CheckRunState maps the status and conclusion of a check run onto the
+// states marks are configured for.
+func CheckRunState(status, conclusion string, conclusions map[string]string) string {
+	switch status {
+	case checkRunQueued:
+		return StatusQueued
+	case checkRunInProgress:
+		return StatusInProgress
+	case checkRunCompleted:
+	default:
+		return StatusPending
+	}
+
+	state, ok := conclusions[conclusion]
+	if !ok {
+		return StatusUnknown
+	}
+
+	return state
+}
+
+type CheckRun struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Conclusion  string     `json:"conclusion"`
+	HTMLURL     string     `json:"html_url"`
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	App         struct {
+		Slug string `json:"slug"`
+	} `json:"app"`
+	Output struct {
+		AnnotationsCount int `json:"annotations_count"`
+	} `json:"output"`
+}
+
+func ListCheckRuns(client *github.Client, remote Remote, rev string) ([]CheckRun, error) {
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/commits/%s/check-runs?per_page=100", remote.Owner, remote.Name, rev), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		CheckRuns []CheckRun `json:"check_runs"`
+	}
+	_, err = client.Do(req, &result)
+	return result.CheckRuns, err
+}
+
+// checkRunContexts turns check runs into contexts, so they roll up together
+// with legacy statuses.
+func checkRunContexts(runs []CheckRun) []ContextStatus {
+	conclusions := ConclusionStates()
+
+	contexts := []ContextStatus{}
+	for _, run := range runs {
+		description := run.Conclusion
+		if description == "" {
+			description = run.Status
+		}
+
+		c := ContextStatus{
+			Context:     run.Name,
+			State:       CheckRunState(run.Status, run.Conclusion, conclusions),
+			Description: description,
+			TargetURL:   run.HTMLURL,
+			Creator:     run.App.Slug,
+		}
+		if run.CompletedAt != nil {
+			c.UpdatedAt = *run.CompletedAt
+		} else if run.StartedAt != nil {
+			c.UpdatedAt = *run.StartedAt
+		}
+
+		contexts = append(contexts, c)
+	}
+
+	return contexts
+}
@@ -0,0 +1,521 @@
+package statusmark
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	osUser "os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-netrc/netrc"
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+	"gopkg.in/yaml.v2"
+)
+
+// tokenEnvNames are the environment variables of gh and GitHub Actions
+// holding tokens, by whether they are for github.com, in order of
+// precedence.
+var tokenEnvNames = map[bool][]string{
+	true:  {"GH_TOKEN", "GITHUB_TOKEN"},
+	false: {"GH_ENTERPRISE_TOKEN", "GITHUB_ENTERPRISE_TOKEN"},
+}
+
+// providerTokenEnvNames are those of the other providers' CLIs, in place of
+// tokenEnvNames.
+var providerTokenEnvNames = map[string][]string{
+	ProviderGitLab:  {"GITLAB_TOKEN"},
+	ProviderGitea:   {"GITEA_TOKEN", "FORGEJO_TOKEN"},
+	ProviderForgejo: {"FORGEJO_TOKEN", "GITEA_TOKEN"},
+	// Access tokens; app passwords go with a user name, see bitbucket
+	ProviderBitbucket: {"BITBUCKET_TOKEN"},
+}
+
+// RetrieveAPIToken returns the API token for remoteURL and where it was
+// found. In order, it is looked for in GITHUB_COMMIT_STATUS_MARK_TOKEN,
+// GCSM_TOKEN, GH_TOKEN and GITHUB_TOKEN (GH_ENTERPRISE_TOKEN and
+// GITHUB_ENTERPRISE_TOKEN for other hosts than github.com), the system
+// keyring, ~/.netrc, git config, the tokens table of the user's config
+// file, the configs of gh and hub, and git's credential helpers.
+//
+// Tokens not scoped to a host are taken for GitHub only, so that a GitHub
+// token is never sent to another provider: GitLab takes GITLAB_TOKEN,
+// Gitea and Forgejo GITEA_TOKEN and FORGEJO_TOKEN, and Bitbucket
+// BITBUCKET_TOKEN in place of the environment variables, and only the git
+// config for its URL, e.g. github-commit-status.https://gitlab.com.token.
+func RetrieveAPIToken(remoteURL *url.URL) (token string, source string) {
+	// try environment variable
+	envNames := append([]string{"GITHUB_COMMIT_STATUS_MARK_TOKEN", envName("token")}, tokenEnvNames[remoteURL.Host == "github.com"]...)
+	if names, ok := providerTokenEnvNames[ProviderOf(remoteURL)]; ok {
+		envNames = names
+	}
+	for _, name := range envNames {
+		if token = os.Getenv(name); token != "" {
+			return token, name
+		}
+	}
+
+	// ..then the system keyring, where login stores it
+	if token = keyringToken(remoteURL.Host); token != "" {
+		return token, "the system keyring"
+	}
+
+	// ..then .netrc
+	apiHost := remoteURL.Host
+	if apiHost == "github.com" {
+		apiHost = "api.github.com"
+	}
+	if machine, netrcFile := netrcMachine(apiHost); machine != nil {
+		return machine.Password, netrcFile
+	}
+
+	// ..then git config
+	if token = tokenConfig(remoteURL); token != "" {
+		return token, "git config"
+	}
+
+	// ..then the user's config file
+	if token, source = userConfigToken(remoteURL); token != "" {
+		return token, source
+	}
+
+	// ..then the configs of gh and hub, which may have logged in already
+	if token, source = ghToken(remoteURL.Host); token != "" {
+		return token, source
+	}
+	if token, source = hubToken(remoteURL.Host); token != "" {
+		return token, source
+	}
+
+	// ..then git's credential helpers, if asked to, as they may be slow
+	if ConfigBool("useCredentialHelper") {
+		if token = gitCredentialToken(remoteURL); token != "" {
+			return token, "git credential"
+		}
+	}
+
+	return "", ""
+}
+
+// tokenConfig returns the token set in git config for remoteURL. For other
+// providers than GitHub, it must be set for the URL, as the token set for
+// all is taken to be for GitHub.
+func tokenConfig(remoteURL *url.URL) string {
+	if ProviderOf(remoteURL) == ProviderGitHub {
+		return configURLValue("token", remoteURL)
+	}
+
+	token := GitConfig("--get-urlmatch", "github-commit-status.token", remoteURL.String())
+	if token == GitConfig("--get", "github-commit-status.token") {
+		return ""
+	}
+
+	return token
+}
+
+// netrcMachine returns the entry of ~/.netrc with a password for host, and
+// the path of the file.
+func netrcMachine(host string) (*netrc.Machine, string) {
+	user, _ := osUser.Current()
+	if user == nil {
+		return nil, ""
+	}
+
+	netrcFile := filepath.Join(user.HomeDir, ".netrc")
+	if fi, _ := os.Stat(netrcFile); fi == nil {
+		return nil, ""
+	}
+
+	machine, _ := netrc.FindMachine(netrcFile, host)
+	// ignore "default" machine
+	if machine == nil || machine.Name == "" || machine.Password == "" {
+		return nil, ""
+	}
+
+	return machine, netrcFile
+}
+
+// userConfigToken returns the token for remoteURL in the tokens table of
+// the user's config file, keyed by host and owner or by host alone, for
+// those with accounts on several hosts or organizations:
+//
+//	[tokens]
+//	"github.com" = "..."
+//	"github.com/work-org" = "..."
+//	"ghe.example.com" = "..."
+//
+// In git config the same is done with URL-specific settings such as
+// github-commit-status.https://github.com/work-org.token.
+func userConfigToken(remoteURL *url.URL) (string, string) {
+	keys := []string{"tokens." + remoteURL.Host}
+	if owner := strings.SplitN(strings.TrimPrefix(remoteURL.Path, "/"), "/", 2)[0]; owner != "" {
+		keys = append([]string{"tokens." + remoteURL.Host + "/" + owner}, keys...)
+	}
+
+	for _, key := range keys {
+		if token := loadUserConfig()[key]; token != "" {
+			return token, userConfigPath() + " (" + key + ")"
+		}
+	}
+
+	return "", ""
+}
+
+// gitCredentialToken returns the password git's credential helpers have for
+// the host of remoteURL, which is the token for GitHub. Nothing is
+// prompted for, as a prompt would hang a shell prompt.
+func gitCredentialToken(remoteURL *url.URL) string {
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=https\nhost=%s\n\n", remoteURL.Host))
+	cmd.Env = append(cmd.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never", "GIT_ASKPASS=true")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "password=") {
+			return strings.TrimPrefix(line, "password=")
+		}
+	}
+
+	return ""
+}
+
+// ghToken returns the token gh auth login stored for host in hosts.yml,
+// unless gh keeps it in the system keyring.
+func ghToken(host string) (string, string) {
+	dir := os.Getenv("GH_CONFIG_DIR")
+	if dir == "" {
+		dir = filepath.Join(configHome(), "gh")
+	}
+	path := filepath.Join(dir, "hosts.yml")
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", ""
+	}
+
+	var hosts map[string]struct {
+		OAuthToken string `yaml:"oauth_token"`
+	}
+	if err := yaml.Unmarshal(buf, &hosts); err != nil {
+		return "", ""
+	}
+
+	return hosts[host].OAuthToken, path
+}
+
+// hubToken returns the token hub stored for host, in $HUB_CONFIG or
+// ~/.config/hub.
+func hubToken(host string) (string, string) {
+	path := os.Getenv("HUB_CONFIG")
+	if path == "" {
+		path = filepath.Join(configHome(), "hub")
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", ""
+	}
+
+	var hosts map[string][]struct {
+		OAuthToken string `yaml:"oauth_token"`
+	}
+	if err := yaml.Unmarshal(buf, &hosts); err != nil {
+		return "", ""
+	}
+
+	for _, entry := range hosts[host] {
+		if entry.OAuthToken != "" {
+			return entry.OAuthToken, path
+		}
+	}
+
+	return "", ""
+}
+
+type ClientOptions struct {
+	RetryPolicy RetryPolicy
+	// TokenSource, if set, authenticates requests in place of the token
+	// configured for the host, e.g. with tokens that expire and are
+	// refreshed
+	TokenSource oauth2.TokenSource
+	// APICalls, if set, counts requests sent to the API
+	APICalls *int64
+	// Trail, if set, records requests sent to the API
+	Trail *Explanation
+	// DryRun prints requests instead of sending them
+	DryRun bool
+	// ETags, if set, makes requests conditional on the ETags by URL, and
+	// receives those of new responses
+	ETags map[string]string
+	// RateLimits, if set, receives the API budget of the host
+	RateLimits map[string]RateLimit
+	// Context, if set, cancels requests when done
+	Context context.Context
+	// BasicAuth, if set, authenticates requests with a user name and
+	// password in place of a token, as app passwords of Bitbucket do
+	BasicAuth *url.Userinfo
+	// APIBases, if set, remembers the API roots probed by host
+	APIBases map[string]string
+}
+
+// WrapTransport, if set, wraps the transport of every API client, e.g. to
+// trace the requests sent.
+var WrapTransport func(http.RoundTripper) http.RoundTripper
+
+var (
+	transports   = map[string]*http.Transport{}
+	transportsMu sync.Mutex
+)
+
+// sharedTransport returns the transport for remoteURL, built once so that
+// clients for the same repository share their connections. If it cannot be
+// built as configured, e.g. as the CA file is missing, it fails every
+// request with why.
+func sharedTransport(remoteURL *url.URL) http.RoundTripper {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if t, ok := transports[remoteURL.String()]; ok {
+		return t
+	}
+
+	t, err := newHTTPTransport(remoteURL)
+	if err != nil {
+		return brokenTransport{err: err}
+	}
+	transports[remoteURL.String()] = t
+
+	return t
+}
+
+// brokenTransport fails every request with err, so that settings that
+// cannot be used are reported where requests are, like other failures.
+type brokenTransport struct {
+	err error
+}
+
+func (t brokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// NewAPIClient returns the client of the API of the host of remoteURL. If
+// its settings cannot be used, its requests fail with why.
+func NewAPIClient(remoteURL *url.URL, opts ClientOptions) *github.Client {
+	base, err := apiBaseURL(remoteURL)
+	if err != nil {
+		return github.NewClient(&http.Client{Transport: brokenTransport{err: err}})
+	}
+
+	client := github.NewClient(newHTTPClient(remoteURL, opts))
+
+	client.BaseURL = base
+	if opts.APIBases != nil && !opts.DryRun && remoteURL.Host != "github.com" && configuredAPIBase(remoteURL) == "" {
+		client.BaseURL = probedAPIBase(remoteURL, opts.APIBases)
+	}
+
+	return client
+}
+
+// newHTTPClient returns the client sending API requests to the host of
+// remoteURL as opts say, authenticated with its token.
+func newHTTPClient(remoteURL *url.URL, opts ClientOptions) *http.Client {
+	tokenSource, tokenDescription := opts.TokenSource, "the client options"
+	if opts.BasicAuth != nil {
+		tokenDescription = "the password of " + opts.BasicAuth.Username()
+	} else if tokenSource == nil {
+		tokenSource, tokenDescription = TokenSource(remoteURL)
+	}
+
+	transport := sharedTransport(remoteURL)
+	if opts.DryRun {
+		transport = &dryRunTransport{tokenSource: tokenDescription}
+	}
+
+	if opts.RateLimits != nil {
+		transport = &rateLimitTransport{base: transport, host: remoteURL.Host, limits: opts.RateLimits}
+	}
+
+	transport = &retryTransport{
+		base: &countingTransport{
+			base:  transport,
+			count: opts.APICalls,
+			trail: opts.Trail,
+		},
+		policy: opts.RetryPolicy,
+	}
+
+	if opts.ETags != nil {
+		transport = &etagTransport{base: transport, etags: opts.ETags}
+	}
+
+	transport = &headerTransport{
+		base:       transport,
+		apiVersion: configURLValue("apiVersion", remoteURL),
+		accept:     configURLValue("accept", remoteURL),
+	}
+
+	if opts.BasicAuth != nil {
+		transport = &basicAuthTransport{base: transport, user: opts.BasicAuth}
+	} else if tokenSource != nil {
+		transport = &oauth2.Transport{
+			Source: tokenSource,
+			Base:   transport,
+		}
+	}
+
+	if WrapTransport != nil {
+		transport = WrapTransport(transport)
+	}
+
+	if opts.Context != nil {
+		transport = &contextTransport{base: transport, ctx: opts.Context}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+type basicAuthTransport struct {
+	base http.RoundTripper
+	user *url.Userinfo
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	password, _ := t.user.Password()
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.user.Username(), password)
+
+	return t.base.RoundTrip(req)
+}
+
+// contextTransport sends requests with ctx, as go-github predates
+// contexts, so that they are given up when it is done.
+type contextTransport struct {
+	base http.RoundTripper
+	ctx  context.Context
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// APIBase is -api-base, taking precedence over github-commit-status.apiBase.
+var APIBase string
+
+// configuredAPIBase returns -api-base, or github-commit-status.apiBase for
+// remoteURL, e.g. github-commit-status.https://ghe.example.com.apiBase.
+func configuredAPIBase(remoteURL *url.URL) string {
+	if APIBase != "" {
+		return APIBase
+	}
+
+	return configURLValue("apiBase", remoteURL)
+}
+
+func parseAPIBase(base string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(base, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid API base: %s", err)
+	}
+
+	return u, nil
+}
+
+// apiBaseURL returns the root of the REST API for the host of remoteURL, as
+// configured or else as usual for github.com and GitHub Enterprise.
+func apiBaseURL(remoteURL *url.URL) (*url.URL, error) {
+	if base := configuredAPIBase(remoteURL); base != "" {
+		return parseAPIBase(base)
+	}
+
+	if remoteURL.Host == "github.com" {
+		return &url.URL{Scheme: "https", Host: "api.github.com", Path: "/"}, nil
+	}
+
+	return &url.URL{Scheme: "https", Host: remoteURL.Host, Path: "/api/v3/"}, nil
+}
+
+// APIRoot returns the root of the API of the host of remoteURL for its
+// provider, as configured or else as usual.
+func APIRoot(remoteURL *url.URL) (*url.URL, error) {
+	p, ok := providers[ProviderOf(remoteURL)]
+	if !ok {
+		return apiBaseURL(remoteURL)
+	}
+	if base := configuredAPIBase(remoteURL); base != "" {
+		return parseAPIBase(base)
+	}
+
+	return p.apiBase(remoteURL), nil
+}
+
+// CheckConnection sends a request without credentials to root through the
+// transport of the API requests for remoteURL, so with the same CA, proxy
+// and verification settings, and returns why no answer came within
+// timeout, if none did. What the answer is does not matter.
+func CheckConnection(remoteURL, root *url.URL, timeout time.Duration) error {
+	client := &http.Client{Transport: sharedTransport(remoteURL), Timeout: timeout}
+	resp, err := client.Head(root.String())
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+const apiProbeTimeout = 2 * time.Second
+
+func (state *Cache) apiBases() map[string]string {
+	if state.APIBases == nil {
+		state.APIBases = map[string]string{}
+	}
+
+	return state.APIBases
+}
+
+// probedAPIBase returns the root of the REST API of the Enterprise server of
+// remoteURL, which is under /api/v3/ of the host, or at the root of its api.
+// subdomain when served with subdomain isolation. The one answering like the
+// API is remembered in probed; if neither does, /api/v3/ is assumed.
+func probedAPIBase(remoteURL *url.URL, probed map[string]string) *url.URL {
+	if base, ok := probed[remoteURL.Host]; ok {
+		if u, err := url.Parse(base); err == nil {
+			return u
+		}
+	}
+
+	// Only asked when no API base is configured, so this cannot fail
+	base, _ := apiBaseURL(remoteURL)
+	candidates := []*url.URL{base}
+	if net.ParseIP(remoteURL.Hostname()) == nil {
+		candidates = append(candidates, &url.URL{Scheme: "https", Host: "api." + remoteURL.Host, Path: "/"})
+	}
+
+	client := &http.Client{Transport: sharedTransport(remoteURL), Timeout: apiProbeTimeout}
+	for _, base := range candidates {
+		resp, err := client.Get(base.ResolveReference(&url.URL{Path: "meta"}).String())
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		// Private instances answer 401 without a token
+		if resp.StatusCode != http.StatusNotFound && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+			probed[remoteURL.Host] = base.String()
+			return base
+		}
+	}
+
+	return candidates[0]
+}
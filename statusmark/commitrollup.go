@@ -0,0 +1,200 @@
+package statusmark
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// rollupContextsSelection selects the contexts of a statusCheckRollup as
+// rollupNodes.
+const rollupContextsSelection = `
+contexts(first: 100) {
+  nodes {
+    __typename
+    ... on StatusContext {
+      context state description targetUrl createdAt
+      creator { login }
+    }
+    ... on CheckRun {
+      name status conclusion detailsUrl startedAt completedAt
+      checkSuite { app { slug } }
+    }
+  }
+}`
+
+const commitRollupQuery = `
+query($owner: String!, $name: String!, $oid: GitObjectID!) {
+  repository(owner: $owner, name: $name) {
+    object(oid: $oid) {
+      ... on Commit {
+        statusCheckRollup {` + rollupContextsSelection + `
+        }
+      }
+    }
+  }
+}`
+
+type rollupNode struct {
+	Typename string `json:"__typename"`
+
+	// StatusContext
+	Context     string    `json:"context"`
+	State       string    `json:"state"`
+	Description string    `json:"description"`
+	TargetURL   string    `json:"targetUrl"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Creator     *struct {
+		Login string `json:"login"`
+	} `json:"creator"`
+
+	// CheckRun
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Conclusion  string     `json:"conclusion"`
+	DetailsURL  string     `json:"detailsUrl"`
+	StartedAt   *time.Time `json:"startedAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	CheckSuite  struct {
+		App *struct {
+			Slug string `json:"slug"`
+		} `json:"app"`
+	} `json:"checkSuite"`
+}
+
+// commitRollupContexts returns the statuses and check runs of rev from its
+// statusCheckRollup, in one query where REST takes one request for each.
+// found is false if the repository has no such commit.
+func commitRollupContexts(client *github.Client, remote Remote, rev string) (contexts []ContextStatus, found bool, err error) {
+	var data struct {
+		Repository struct {
+			Object *struct {
+				StatusCheckRollup *struct {
+					Contexts struct {
+						Nodes []rollupNode `json:"nodes"`
+					} `json:"contexts"`
+				} `json:"statusCheckRollup"`
+			} `json:"object"`
+		} `json:"repository"`
+	}
+
+	err = graphQL(client, commitRollupQuery, map[string]interface{}{
+		"owner": remote.Owner,
+		"name":  remote.Name,
+		"oid":   rev,
+	}, &data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	object := data.Repository.Object
+	if object == nil {
+		return nil, false, nil
+	}
+
+	if object.StatusCheckRollup == nil {
+		return []ContextStatus{}, true, nil
+	}
+
+	return rollupNodeContexts(object.StatusCheckRollup.Contexts.Nodes), true, nil
+}
+
+// rollupNodeContexts returns the statuses and check runs of nodes as
+// contexts.
+func rollupNodeContexts(nodes []rollupNode) []ContextStatus {
+	contexts := []ContextStatus{}
+
+	// Check runs as REST has them, for the same states and descriptions
+	var runs []CheckRun
+	for _, n := range nodes {
+		switch n.Typename {
+		case "StatusContext":
+			c := ContextStatus{
+				Context:     n.Context,
+				State:       rollupState(n.State),
+				Description: n.Description,
+				TargetURL:   n.TargetURL,
+				UpdatedAt:   n.CreatedAt,
+			}
+			if n.Creator != nil {
+				c.Creator = n.Creator.Login
+			}
+			contexts = append(contexts, c)
+
+		case "CheckRun":
+			run := CheckRun{
+				Name:        n.Name,
+				Status:      strings.ToLower(n.Status),
+				Conclusion:  strings.ToLower(n.Conclusion),
+				HTMLURL:     n.DetailsURL,
+				StartedAt:   n.StartedAt,
+				CompletedAt: n.CompletedAt,
+			}
+			if n.CheckSuite.App != nil {
+				run.App.Slug = n.CheckSuite.App.Slug
+			}
+			runs = append(runs, run)
+		}
+	}
+
+	return append(contexts, checkRunContexts(runs)...)
+}
+
+// fetchRollup looks up rev with one GraphQL query on the first remote, or
+// its upstream if looking there. It is not ok if the host is too old for
+// statusCheckRollup, the query fails or the commit is not found, for the
+// REST API to be asked instead.
+func (l *Lookup) fetchRollup(rev string) (entry Entry, remote Remote, client *github.Client, ok bool, err error) {
+	name := ConfiguredRemotes(l.Repo)[0]
+	if remote, err = ParseRemote(l.Repo, name); err != nil {
+		return entry, remote, nil, false, err
+	}
+	l.Trail.Add("remote: %s (%s/%s on %s)", name, remote.Owner, remote.Name, remote.URL.Host)
+
+	client = l.APIClient(remote)
+	if host := l.Cache.HostInfo(client, remote); !host.Supports(FeatureStatusCheckRollup) {
+		l.Trail.Add("graphql: GitHub Enterprise %s has no statusCheckRollup; using REST", host.Version)
+		return entry, remote, client, false, nil
+	}
+
+	if l.Upstream {
+		if upstream := l.Cache.upstreamOf(client, remote); upstream != remote {
+			remote = upstream
+			l.Trail.Add("remote: using upstream %s/%s", remote.Owner, remote.Name)
+		}
+	}
+
+	fetchStart := time.Now()
+	contexts, found, err := commitRollupContexts(client, remote, rev)
+	l.Cache.Stats.recordFetch(time.Since(fetchStart))
+	if err != nil {
+		l.Trail.Add("graphql: %s; using REST", err)
+		return entry, remote, client, false, nil
+	}
+	if !found {
+		l.Trail.Add("graphql: %s/%s does not know %s; using REST", remote.Owner, remote.Name, rev)
+		return entry, remote, client, false, nil
+	}
+
+	entry = Entry{
+		Status:       StatusUnknown,
+		LastModified: time.Now().Unix(),
+	}
+	var filtered bool
+	entry.Contexts, filtered = applyContextSettings(contexts)
+	if err := l.rollUp(&entry, filtered, rev); err != nil {
+		return entry, remote, client, false, err
+	}
+
+	if l.PullRequest {
+		l.preferPullRequest(&entry, client, remote, rev)
+	}
+
+	if l.Cache.Revisions == nil {
+		l.Cache.Revisions = map[string]Entry{}
+	}
+	l.Cache.Revisions[l.cacheKey(rev)] = entry
+
+	return entry, remote, client, true, nil
+}
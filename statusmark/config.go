@@ -0,0 +1,305 @@
+package statusmark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Profile is the name of the active configuration profile, from -profile or
+// GITHUB_COMMIT_STATUS_MARK_PROFILE. Settings under
+// github-commit-status-profile.<profile>.* take precedence over the plain
+// github-commit-status.* ones.
+var Profile = os.Getenv("GITHUB_COMMIT_STATUS_MARK_PROFILE")
+
+func GitConfig(args ...string) string {
+	if len(args) == 2 && args[0] == "--get" {
+		return gitConfigValues()[canonicalConfigKey(args[1])]
+	}
+
+	buf, err := exec.Command("git", append([]string{"config"}, args...)...).Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimRight(string(buf), "\n")
+}
+
+var (
+	gitConfigMu sync.Mutex
+	// gitConfigs are the settings of `git config -l` by working directory,
+	// which decides the repository whose config is read
+	gitConfigs = map[string]map[string]string{}
+)
+
+// gitConfigValues returns all settings of git config, read once per working
+// directory rather than running git for every key looked up. The last value
+// of a multi-valued key wins, as with --get.
+func gitConfigValues() map[string]string {
+	dir, _ := os.Getwd()
+
+	gitConfigMu.Lock()
+	defer gitConfigMu.Unlock()
+
+	if values, ok := gitConfigs[dir]; ok {
+		return values
+	}
+
+	values := map[string]string{}
+	buf, _ := exec.Command("git", "config", "-l", "-z").Output()
+	for _, item := range strings.Split(string(buf), "\x00") {
+		kv := strings.SplitN(item, "\n", 2)
+		if kv[0] == "" {
+			continue
+		}
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		values[kv[0]] = kv[1]
+	}
+
+	gitConfigs[dir] = values
+	return values
+}
+
+// forgetGitConfig makes the next lookup read git config again.
+func forgetGitConfig() {
+	gitConfigMu.Lock()
+	gitConfigs = map[string]map[string]string{}
+	gitConfigMu.Unlock()
+}
+
+// canonicalConfigKey lowercases the section and variable names of key as
+// git does, leaving any subsection as it is.
+func canonicalConfigKey(key string) string {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first < 0 {
+		return strings.ToLower(key)
+	}
+
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+// envName returns the environment variable overriding key, e.g.
+// GCSM_PROMPT_RETRY_MAX_ATTEMPTS for promptRetryMaxAttempts and
+// GCSM_FAILURE_MARK for failure.mark.
+func envName(key string) string {
+	var name []rune
+	for i, r := range key {
+		if r == '.' {
+			r = '_'
+		} else if unicode.IsUpper(r) && i > 0 {
+			name = append(name, '_')
+		}
+		name = append(name, unicode.ToUpper(r))
+	}
+
+	return "GCSM_" + string(name)
+}
+
+// ConfigValue returns the setting key from the GCSM_* environment variable,
+// github-commit-status.<key> in git config, the user's config file or the
+// repository's .github-commit-status.toml, or an empty string if it is not
+// set.
+func ConfigValue(key string) string {
+	if v := os.Getenv(envName(key)); v != "" {
+		return v
+	}
+
+	if Profile != "" {
+		if v := GitConfig("--get", "github-commit-status-profile."+Profile+"."+key); v != "" {
+			return v
+		}
+	}
+
+	if v := GitConfig("--get", "github-commit-status."+key); v != "" {
+		return v
+	}
+
+	if v := loadUserConfig()[key]; v != "" {
+		return v
+	}
+
+	return repoConfig[key]
+}
+
+// configHome returns $XDG_CONFIG_HOME, or ~/.config.
+func configHome() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config")
+}
+
+var (
+	userConfig     map[string]string
+	userConfigOnce sync.Once
+)
+
+// userConfigPath returns the user's config file, config.toml or
+// config.yaml in ~/.config/github-commit-status-mark, or "" if
+// there is none.
+func userConfigPath() string {
+	for _, name := range []string{"config.toml", "config.yaml", "config.yml"} {
+		path := filepath.Join(configHome(), "github-commit-status-mark", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// ReloadConfig makes settings be read again from git config and the user's
+// config file, as after they have been edited.
+func ReloadConfig() {
+	userConfig = nil
+	userConfigOnce = sync.Once{}
+	statusSettings = nil
+	forgetGitConfig()
+}
+
+// loadUserConfig reads the user's config file the first time it is called.
+// Its keys are those of git config, with tables for subsections such as
+// [failure] for failure.mark. A broken file is reported once and ignored,
+// so that it does not break the prompt.
+func loadUserConfig() map[string]string {
+	userConfigOnce.Do(func() {
+		path := userConfigPath()
+		if path == "" {
+			return
+		}
+
+		var values map[string]interface{}
+		var err error
+		if filepath.Ext(path) == ".toml" {
+			_, err = toml.DecodeFile(path, &values)
+		} else {
+			var buf []byte
+			buf, err = ioutil.ReadFile(path)
+			if err == nil {
+				err = yaml.Unmarshal(buf, &values)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			return
+		}
+
+		userConfig = map[string]string{}
+		flattenConfig("", values, userConfig)
+	})
+
+	return userConfig
+}
+
+// flattenConfig stores the settings of a config file into config by their
+// dotted keys. Arrays are joined with spaces, like the lists in git config.
+func flattenConfig(prefix string, values map[string]interface{}, config map[string]string) {
+	for key, value := range values {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flattenConfig(prefix+key+".", value, config)
+		case map[interface{}]interface{}:
+			// As YAML decodes mappings
+			table := map[string]interface{}{}
+			for k, v := range value {
+				table[fmt.Sprint(k)] = v
+			}
+			flattenConfig(prefix+key+".", table, config)
+		case []interface{}:
+			words := make([]string, len(value))
+			for i, v := range value {
+				words[i] = fmt.Sprint(v)
+			}
+			config[prefix+key] = strings.Join(words, " ")
+		default:
+			config[prefix+key] = fmt.Sprint(value)
+		}
+	}
+}
+
+const repoConfigFile = ".github-commit-status.toml"
+
+// repoConfig holds settings from the repository's own config file, which
+// is only read when github-commit-status.trustRepoConfig is true, as a
+// cloned repository must not be able to e.g. run its own roll-up script.
+var repoConfig map[string]string
+
+// LoadRepoConfig reads toplevel/.github-commit-status.toml if trusted.
+func LoadRepoConfig(toplevel string) error {
+	// Forget the settings of any repository loaded before
+	repoConfig = nil
+	statusSettings = nil
+	forgetGitConfig()
+
+	if !ConfigBool("trustRepoConfig") {
+		return nil
+	}
+
+	var values map[string]interface{}
+	_, err := toml.DecodeFile(filepath.Join(toplevel, repoConfigFile), &values)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	repoConfig = map[string]string{}
+	flattenConfig("", values, repoConfig)
+
+	return nil
+}
+
+// configURLValue is like ConfigValue but honors URL-specific settings such as
+// github-commit-status.https://ghe.example.com.<key>.
+func configURLValue(key string, u *url.URL) string {
+	if v := os.Getenv(envName(key)); v != "" {
+		return v
+	}
+
+	if Profile != "" {
+		if v := GitConfig("--get", "github-commit-status-profile."+Profile+"."+key); v != "" {
+			return v
+		}
+	}
+
+	return GitConfig("--get-urlmatch", "github-commit-status."+key, u.String())
+}
+
+func ConfigInt(key string, def int) int {
+	n, err := strconv.Atoi(ConfigValue(key))
+	if err != nil {
+		return def
+	}
+
+	return n
+}
+
+func ConfigBool(key string) bool {
+	b, _ := strconv.ParseBool(ConfigValue(key))
+	return b
+}
+
+func ConfigDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(ConfigValue(key))
+	if err != nil {
+		return def
+	}
+
+	return d
+}
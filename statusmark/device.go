@@ -0,0 +1,60 @@
+package statusmark
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+// DeviceScope is the only scope asked for in the device flow; reading
+// statuses needs nothing more.
+const DeviceScope = "repo:status"
+
+// CanDeviceLogin reports whether an OAuth app is set for host, as
+// DeviceLogin needs. None is built in.
+func CanDeviceLogin(host string) bool {
+	return configURLValue("oauthClientID", &url.URL{Scheme: "https", Host: host}) != ""
+}
+
+// DeviceLogin obtains a token for host by the OAuth device authorization
+// flow, with the client ID of the OAuth app in
+// github-commit-status.<url>.oauthClientID. prompt is called with the code
+// the user should enter at the verification URI, after which DeviceLogin
+// polls until they do, deny or the code expires.
+func DeviceLogin(host string, prompt func(userCode, verificationURI string)) (string, error) {
+	hostURL := &url.URL{Scheme: "https", Host: host}
+
+	clientID := configURLValue("oauthClientID", hostURL)
+	if clientID == "" {
+		return "", fmt.Errorf("no OAuth app set for %s; set github-commit-status.oauthClientID to its client ID", host)
+	}
+
+	config := oauth2.Config{
+		ClientID: clientID,
+		Scopes:   []string{DeviceScope},
+		Endpoint: oauth2.Endpoint{
+			DeviceAuthURL: hostURL.String() + "/login/device/code",
+			TokenURL:      hostURL.String() + "/login/oauth/access_token",
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: sharedTransport(hostURL)})
+
+	auth, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	prompt(auth.UserCode, auth.VerificationURI)
+
+	token, err := config.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		return "", err
+	}
+
+	return token.AccessToken, nil
+}
@@ -0,0 +1,31 @@
+package statusmark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// dryRunTransport prints requests instead of sending them, answering each
+// with an empty successful response so the caller carries on as if there
+// were simply no data.
+type dryRunTransport struct {
+	tokenSource string
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	auth := "none"
+	if t.tokenSource != "" {
+		auth = "token from " + t.tokenSource
+	}
+	fmt.Printf("%s %s (auth: %s)\n", req.Method, req.URL, auth)
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader("null")),
+		Request:    req,
+	}, nil
+}
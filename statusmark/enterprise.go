@@ -0,0 +1,152 @@
+package statusmark
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	FeatureStatuses = "statuses"
+	FeatureChecks   = "checks"
+	FeatureGraphQL  = "graphql"
+	// statusCheckRollup of commits, for the GraphQL mode
+	FeatureStatusCheckRollup = "statusCheckRollup"
+)
+
+// enterpriseFeatureVersions is the first GitHub Enterprise version that
+// provides each API this tool may use.
+var enterpriseFeatureVersions = map[string]string{
+	FeatureStatuses:          "2.0",
+	FeatureChecks:            "2.14",
+	FeatureGraphQL:           "2.10",
+	FeatureStatusCheckRollup: "3.0",
+}
+
+const (
+	hostVersionCacheFor = 24 * time.Hour
+	// hostErrorCacheFor is how long a server that failed to tell its
+	// version is not asked again
+	hostErrorCacheFor = time.Hour
+)
+
+type HostEntry struct {
+	// Version is empty for github.com, which always has every feature.
+	Version string
+	// Error is why the server failed to tell its version, which is then
+	// taken to support nothing
+	Error        string `json:",omitempty"`
+	LastModified int64
+}
+
+func (h HostEntry) Supports(feature string) bool {
+	if h.Error != "" {
+		return false
+	}
+	if h.Version == "" {
+		return true
+	}
+
+	return compareVersions(h.Version, enterpriseFeatureVersions[feature]) >= 0
+}
+
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func fetchEnterpriseVersion(client *github.Client) (string, error) {
+	req, err := client.NewRequest("GET", "meta", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var meta struct {
+		InstalledVersion string `json:"installed_version"`
+	}
+	resp, err := client.Do(req, &meta)
+	if err != nil {
+		return "", err
+	}
+
+	if meta.InstalledVersion == "" && resp != nil {
+		return resp.Header.Get("X-GitHub-Enterprise-Version"), nil
+	}
+
+	return meta.InstalledVersion, nil
+}
+
+// HostInfo returns what is known about the API host of remote, asking an
+// Enterprise server for its version at most once a day. A server that
+// answers with an error, as those too old to have /meta do, is asked again
+// after an hour.
+func (state *Cache) HostInfo(client *github.Client, remote Remote) HostEntry {
+	host := remote.URL.Host
+	if host == "github.com" {
+		return HostEntry{}
+	}
+
+	entry, ok := state.Hosts[host]
+	cacheFor := hostVersionCacheFor
+	if entry.Error != "" {
+		cacheFor = hostErrorCacheFor
+	}
+	if ok && time.Now().Before(time.Unix(entry.LastModified, 0).Add(cacheFor)) {
+		return entry
+	}
+
+	version, err := fetchEnterpriseVersion(client)
+	if _, answered := err.(*github.ErrorResponse); err != nil && !answered {
+		// The server was not reached, nor will the requests to come be
+		return entry
+	}
+
+	entry = HostEntry{Version: version, LastModified: time.Now().Unix()}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if state.Hosts == nil {
+		state.Hosts = map[string]HostEntry{}
+	}
+	state.Hosts[host] = entry
+
+	return entry
+}
+
+// RequireFeature returns an error unless host provides the API feature.
+func RequireFeature(host HostEntry, remote Remote, feature string) error {
+	if host.Error != "" {
+		return fmt.Errorf(
+			"%s did not tell its GitHub Enterprise version (%s); it may be too old for the %s API, which requires %s or later, or not serve the API there (see github-commit-status.apiBase)",
+			remote.URL.Host, host.Error, feature, enterpriseFeatureVersions[feature],
+		)
+	}
+	if !host.Supports(feature) {
+		return fmt.Errorf(
+			"GitHub Enterprise %s at %s is too old: the %s API requires %s or later",
+			host.Version, remote.URL.Host, feature, enterpriseFeatureVersions[feature],
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package statusmark
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// etagTransport makes GET requests conditional on the ETags of earlier
+// responses to the same URL, and records the ETags of new ones. GitHub
+// answers 304 Not Modified, which does not count against the rate limit,
+// when nothing has changed.
+type etagTransport struct {
+	base  http.RoundTripper
+	mu    sync.Mutex
+	etags map[string]string
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return t.base.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+
+	t.mu.Lock()
+	etag := t.etags[key]
+	t.mu.Unlock()
+
+	if etag != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		t.mu.Lock()
+		t.etags[key] = etag
+		t.mu.Unlock()
+	}
+
+	return resp, nil
+}
+
+func isNotModified(err error) bool {
+	errResp, ok := err.(*github.ErrorResponse)
+	return ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotModified
+}
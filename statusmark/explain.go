@@ -0,0 +1,25 @@
+package statusmark
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Explanation collects the steps that led to a status. Adding to a nil
+// explanation does nothing, so lookups can record unconditionally. It may
+// be added to from concurrent requests.
+type Explanation struct {
+	Lines []string
+	mu    sync.Mutex
+}
+
+func (e *Explanation) Add(format string, args ...interface{}) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Lines = append(e.Lines, fmt.Sprintf(format, args...))
+}
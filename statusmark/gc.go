@@ -0,0 +1,71 @@
+package statusmark
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	defaultCacheMaxEntries = 1000
+	defaultCacheMaxAge     = 90 * 24 * time.Hour
+)
+
+// touch records that the entry for key has just been used, to be set as
+// its LastUsed when the cache is saved.
+func (state *Cache) touch(key string) {
+	if state.used == nil {
+		state.used = map[string]int64{}
+	}
+	state.used[key] = time.Now().Unix()
+}
+
+// addRecorded adds the hits and uses recorded since the last save.
+func (state *Cache) addRecorded() {
+	state.Stats.Hits += state.hits
+	state.hits = 0
+
+	for key, t := range state.used {
+		if entry, ok := state.Revisions[key]; ok && t > entry.LastUsed {
+			entry.LastUsed = t
+			state.Revisions[key] = entry
+		}
+	}
+	state.used = nil
+}
+
+// Prune removes the entries not used for longer than
+// github-commit-status.cacheMaxAge (90 days by default), then the least
+// recently used ones beyond github-commit-status.cacheMaxEntries (1000 by
+// default), so that the cache does not grow forever. It returns how many
+// were removed.
+func (state *Cache) Prune() int {
+	maxEntries := ConfigInt("cacheMaxEntries", defaultCacheMaxEntries)
+	maxAge := ConfigDuration("cacheMaxAge", defaultCacheMaxAge)
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge).Unix()
+	for key, entry := range state.Revisions {
+		if entry.lastUsed() < cutoff {
+			delete(state.Revisions, key)
+			removed++
+		}
+	}
+
+	if maxEntries <= 0 || len(state.Revisions) <= maxEntries {
+		return removed
+	}
+
+	keys := make([]string, 0, len(state.Revisions))
+	for key := range state.Revisions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return state.Revisions[keys[i]].lastUsed() > state.Revisions[keys[j]].lastUsed()
+	})
+	for _, key := range keys[maxEntries:] {
+		delete(state.Revisions, key)
+		removed++
+	}
+
+	return removed
+}
@@ -0,0 +1,374 @@
+package statusmark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// Repository answers the few questions this tool asks git.
+type Repository interface {
+	// Resolve returns the work tree root and the commit rev points to.
+	Resolve(rev string) (toplevel string, sha string, err error)
+	// Toplevel returns the work tree root, or the git directory of bare
+	// repositories, which have none.
+	Toplevel() (string, error)
+	// CommonDir returns the git directory shared by the linked worktrees
+	// of the repository.
+	CommonDir() (string, error)
+	RemoteURL(remote string) (string, error)
+	// Branch returns the checked out branch, or an empty string if HEAD is
+	// detached.
+	Branch() string
+	// BranchRemote returns the remote the checked out branch tracks, if any.
+	BranchRemote() string
+	// IsPushed reports whether sha is contained in any remote-tracking
+	// branch. It is true when there are no remote-tracking branches at all,
+	// as then there is nothing to tell.
+	IsPushed(sha string) bool
+}
+
+// OpenRepository opens the repository in-process with go-git, which saves
+// a fork/exec per query and works without a git binary. The git command is
+// used when go-git cannot handle the repository, and when GIT_DIR or
+// GIT_WORK_TREE point elsewhere, since only git itself honors them fully.
+func OpenRepository() Repository {
+	return OpenRepositoryAt("")
+}
+
+// OpenRepositoryAt is OpenRepository for the repository in dir rather than
+// the current directory, where the git command is then run too. It only
+// answers about the repository itself: settings are still those of the
+// current directory.
+func OpenRepositoryAt(dir string) Repository {
+	if os.Getenv("GIT_DIR") != "" || os.Getenv("GIT_WORK_TREE") != "" {
+		return execRepository{dir: dir}
+	}
+
+	path := dir
+	if path == "" {
+		path = "."
+	}
+	// Linked worktrees keep refs, such as the remote-tracking branches, in
+	// the common directory
+	repo, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{DetectDotGit: true, EnableDotGitCommonDir: true})
+	if err != nil {
+		return execRepository{dir: dir}
+	}
+
+	wt, err := repo.Worktree()
+	if err == git.ErrIsBareRepository {
+		if s, ok := repo.Storer.(*filesystem.Storage); ok {
+			return &goGitRepository{repo: repo, root: s.Filesystem().Root(), exec: execRepository{dir: dir}}
+		}
+	}
+	if err != nil {
+		return execRepository{dir: dir}
+	}
+
+	return &goGitRepository{repo: repo, root: wt.Filesystem.Root(), exec: execRepository{dir: dir}}
+}
+
+type goGitRepository struct {
+	repo *git.Repository
+	root string
+	// exec answers what go-git cannot
+	exec execRepository
+}
+
+func (r *goGitRepository) Resolve(rev string) (string, string, error) {
+	hash, err := r.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		// go-git does not understand every revision syntax, e.g. @{u}
+		return r.exec.Resolve(rev)
+	}
+
+	return r.root, hash.String(), nil
+}
+
+func (r *goGitRepository) Toplevel() (string, error) {
+	return r.root, nil
+}
+
+func (r *goGitRepository) CommonDir() (string, error) {
+	s, ok := r.repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return r.exec.CommonDir()
+	}
+
+	dir, err := filepath.Abs(s.Filesystem().Root())
+	if err != nil {
+		return "", err
+	}
+
+	// The git directory of a linked worktree names the common one
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "commondir"))
+	if os.IsNotExist(err) {
+		return dir, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	common := strings.TrimSpace(string(buf))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(dir, common)
+	}
+
+	return filepath.Clean(common), nil
+}
+
+func (r *goGitRepository) RemoteURL(name string) (string, error) {
+	remote, err := r.repo.Remote(name)
+	// go-git only applies the url.<base>.insteadOf rewrites of the
+	// repository's own config, and one per base at that
+	if err != nil || len(remote.Config().URLs) == 0 || hasURLRewrites() {
+		return r.exec.RemoteURL(name)
+	}
+
+	return remote.Config().URLs[0], nil
+}
+
+func (r *goGitRepository) Branch() string {
+	head, err := r.repo.Head()
+	if err != nil || !head.Name().IsBranch() {
+		return ""
+	}
+
+	return head.Name().Short()
+}
+
+func (r *goGitRepository) BranchRemote() string {
+	branch := r.Branch()
+	if branch == "" {
+		return ""
+	}
+
+	config, err := r.repo.Config()
+	if err != nil || config.Branches[branch] == nil {
+		return ""
+	}
+
+	return config.Branches[branch].Remote
+}
+
+// pushedClockSkew is how much older than a commit the commits that contain
+// it may look, as committer dates are not always in order.
+const pushedClockSkew = 24 * time.Hour
+
+func (r *goGitRepository) IsPushed(sha string) bool {
+	target, err := r.repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		// Commits missing locally, e.g. resolved through the API, cannot be
+		// told apart
+		return true
+	}
+
+	refs, err := r.repo.References()
+	if err != nil {
+		return r.exec.IsPushed(sha)
+	}
+
+	var queue []*object.Commit
+	seen := map[plumbing.Hash]bool{}
+	remotes := false
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsRemote() || ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		remotes = true
+		if c, err := r.repo.CommitObject(ref.Hash()); err == nil && !seen[c.Hash] {
+			seen[c.Hash] = true
+			queue = append(queue, c)
+		}
+		return nil
+	})
+	if !remotes {
+		return true
+	}
+
+	// Walk back from the remote-tracking branches, not past commits too
+	// old to contain the target
+	since := target.Committer.When.Add(-pushedClockSkew)
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if c.Hash == target.Hash {
+			return true
+		}
+		if c.Committer.When.Before(since) {
+			continue
+		}
+		for _, h := range c.ParentHashes {
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			// Parents are missing in shallow clones
+			if p, err := r.repo.CommitObject(h); err == nil {
+				queue = append(queue, p)
+			}
+		}
+	}
+
+	return false
+}
+
+// execRepository asks the git command, run in dir, or the current
+// directory if empty.
+type execRepository struct {
+	dir string
+}
+
+// git runs git in the directory of r.
+func (r execRepository) git(command ...string) (string, error) {
+	return runGitIn(r.dir, command...)
+}
+
+// output runs git in the directory of r, leaving stderr alone.
+func (r execRepository) output(command ...string) (string, error) {
+	cmd := exec.Command("git", command...)
+	cmd.Dir = r.dir
+
+	buf, err := cmd.Output()
+	return strings.TrimRight(string(buf), "\n"), err
+}
+
+func (r execRepository) Resolve(rev string) (string, string, error) {
+	flag, err := r.toplevelFlag()
+	if err != nil {
+		return "", "", err
+	}
+
+	out, err := r.git("rev-parse", flag, rev)
+	if err != nil {
+		return "", "", err
+	}
+
+	lines := strings.Split(out, "\n")
+	if len(lines) < 2 {
+		return "", "", fmt.Errorf("Could not resolve revision: %q", rev)
+	}
+
+	return lines[0], lines[1], nil
+}
+
+func (r execRepository) Toplevel() (string, error) {
+	flag, err := r.toplevelFlag()
+	if err != nil {
+		return "", err
+	}
+
+	return r.git("rev-parse", flag)
+}
+
+// toplevelFlag returns the rev-parse option printing the toplevel, which is
+// the git directory in bare repositories, where --show-toplevel fails.
+func (r execRepository) toplevelFlag() (string, error) {
+	bare, err := r.git("rev-parse", "--is-bare-repository")
+	if err != nil {
+		return "", err
+	}
+	if bare == "true" {
+		return "--absolute-git-dir", nil
+	}
+
+	return "--show-toplevel", nil
+}
+
+func (r execRepository) CommonDir() (string, error) {
+	dir, err := r.git("rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(r.dir, dir)
+	}
+
+	return filepath.Abs(dir)
+}
+
+// RemoteURL returns the URL of the remote as rewritten by
+// url.<base>.insteadOf, e.g. gh:owner/name for git@github.com:owner/name.
+func (r execRepository) RemoteURL(name string) (string, error) {
+	// Without RunGit, which would tell about missing remotes on stderr
+	url, err := r.output("remote", "get-url", name)
+	if err != nil {
+		return "", fmt.Errorf("'git remote get-url %s' failed: %s", name, err)
+	}
+
+	return url, nil
+}
+
+// hasURLRewrites reports whether any url.<base>.insteadOf is set.
+func hasURLRewrites() bool {
+	for key := range gitConfigValues() {
+		if strings.HasPrefix(key, "url.") && strings.HasSuffix(key, ".insteadof") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r execRepository) Branch() string {
+	branch, err := r.output("symbolic-ref", "--short", "-q", "HEAD")
+	if err != nil {
+		return ""
+	}
+
+	return branch
+}
+
+func (r execRepository) BranchRemote() string {
+	branch := r.Branch()
+	if branch == "" {
+		return ""
+	}
+
+	remote, err := r.output("config", "branch."+branch+".remote")
+	if err != nil {
+		return ""
+	}
+
+	return remote
+}
+
+func (r execRepository) IsPushed(sha string) bool {
+	refs, err := r.output("for-each-ref", "--count=1", "--contains", sha, "refs/remotes")
+	if err != nil || refs != "" {
+		// Commits missing locally, e.g. resolved through the API, cannot be
+		// told apart
+		return true
+	}
+
+	refs, err = r.git("for-each-ref", "--count=1", "refs/remotes")
+	return err != nil || refs == ""
+}
+
+func RunGit(command ...string) (string, error) {
+	return runGitIn("", command...)
+}
+
+// runGitIn is RunGit in dir, or the current directory if empty.
+func runGitIn(dir string, command ...string) (string, error) {
+	cmd := exec.Command("git", command...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+
+	buf, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("'git %s' failed: %s", strings.Join(command, " "), err)
+	}
+
+	return strings.TrimRight(string(buf), "\n"), nil
+}
@@ -0,0 +1,65 @@
+package statusmark
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// gitea reads the combined commit status of Gitea and Forgejo, to which
+// their Actions report as well.
+type gitea struct{}
+
+// giteaStates maps the states of Gitea statuses onto the states marks are
+// configured for; they are those of GitHub statuses, and warning.
+var giteaStates = map[string]string{
+	"pending": StatusPending,
+	"success": StatusSuccess,
+	"error":   StatusError,
+	"failure": StatusFailure,
+	"warning": StatusWarning,
+}
+
+func (gitea) apiBase(remoteURL *url.URL) *url.URL {
+	return &url.URL{Scheme: "https", Host: remoteURL.Host, Path: "/api/v1/"}
+}
+
+// contexts lists the statuses of the combined status, which is the latest
+// of each context.
+func (gitea) contexts(client *http.Client, base *url.URL, remote Remote, sha string) ([]ContextStatus, error) {
+	var combined struct {
+		Statuses []struct {
+			Context     string    `json:"context"`
+			Status      string    `json:"status"`
+			Description string    `json:"description"`
+			TargetURL   string    `json:"target_url"`
+			UpdatedAt   time.Time `json:"updated_at"`
+			Creator     struct {
+				Login string `json:"login"`
+			} `json:"creator"`
+		} `json:"statuses"`
+	}
+	u := base.String() + "repos/" + url.PathEscape(remote.Owner) + "/" + url.PathEscape(remote.Name) + "/commits/" + sha + "/status?limit=100"
+	if err := getJSON(client, u, &combined); err != nil {
+		return nil, err
+	}
+
+	contexts := []ContextStatus{}
+	for _, s := range combined.Statuses {
+		state, ok := giteaStates[s.Status]
+		if !ok {
+			state = StatusUnknown
+		}
+
+		contexts = append(contexts, ContextStatus{
+			Context:     s.Context,
+			State:       state,
+			Description: s.Description,
+			TargetURL:   s.TargetURL,
+			Creator:     s.Creator.Login,
+			UpdatedAt:   s.UpdatedAt,
+		})
+	}
+
+	return contexts, nil
+}
@@ -0,0 +1,84 @@
+package statusmark
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// gitLab reads the commit statuses of GitLab, which include the jobs of its
+// pipelines as well as statuses posted by other services.
+type gitLab struct{}
+
+// gitLabStates maps the states of GitLab jobs and statuses onto the states
+// marks are configured for.
+var gitLabStates = map[string]string{
+	"created":              StatusQueued,
+	"waiting_for_resource": StatusQueued,
+	"preparing":            StatusQueued,
+	"pending":              StatusQueued,
+	"scheduled":            StatusQueued,
+	"running":              StatusInProgress,
+	"success":              StatusSuccess,
+	"failed":               StatusFailure,
+	"canceled":             StatusFailure,
+	"skipped":              StatusSuccess,
+	"manual":               StatusActionRequired,
+}
+
+type gitLabStatus struct {
+	Name         string     `json:"name"`
+	Status       string     `json:"status"`
+	Description  string     `json:"description"`
+	TargetURL    string     `json:"target_url"`
+	AllowFailure bool       `json:"allow_failure"`
+	CreatedAt    time.Time  `json:"created_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+	Author       struct {
+		Username string `json:"username"`
+	} `json:"author"`
+}
+
+func (gitLab) apiBase(remoteURL *url.URL) *url.URL {
+	return &url.URL{Scheme: "https", Host: remoteURL.Host, Path: "/api/v4/"}
+}
+
+// contexts lists the latest status of each name. Projects are named by
+// their full path, as they may be in subgroups.
+func (gitLab) contexts(client *http.Client, base *url.URL, remote Remote, sha string) ([]ContextStatus, error) {
+	project := url.PathEscape(strings.TrimPrefix(remote.URL.Path, "/"))
+
+	var statuses []gitLabStatus
+	if err := getJSON(client, base.String()+"projects/"+project+"/repository/commits/"+sha+"/statuses?per_page=100", &statuses); err != nil {
+		return nil, err
+	}
+
+	contexts := []ContextStatus{}
+	for _, s := range statuses {
+		state, ok := gitLabStates[s.Status]
+		if !ok {
+			state = StatusUnknown
+		}
+		// Shown by GitLab as passed with warnings
+		if state == StatusFailure && s.AllowFailure {
+			state = StatusWarning
+		}
+
+		updatedAt := s.CreatedAt
+		if s.FinishedAt != nil {
+			updatedAt = *s.FinishedAt
+		}
+
+		contexts = append(contexts, ContextStatus{
+			Context:     s.Name,
+			State:       state,
+			Description: s.Description,
+			TargetURL:   s.TargetURL,
+			Creator:     s.Author.Username,
+			UpdatedAt:   updatedAt,
+		})
+	}
+
+	return contexts, nil
+}
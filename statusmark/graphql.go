@@ -0,0 +1,44 @@
+package statusmark
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+// graphQL runs query against the GraphQL endpoint of client's host and
+// decodes its data into out.
+func graphQL(client *github.Client, query string, variables map[string]interface{}, out interface{}) error {
+	// https://api.github.com/graphql, or https://<host>/api/graphql for
+	// Enterprise, whose REST base is /api/v3/
+	req, err := client.NewRequest("POST", "../graphql", map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp := struct {
+		Data   interface{}    `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}{Data: out}
+	if _, err := client.Do(req, &resp); err != nil {
+		return err
+	}
+
+	if len(resp.Errors) > 0 {
+		messages := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			messages[i] = e.Message
+		}
+		return fmt.Errorf("GraphQL: %s", strings.Join(messages, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package statusmark
+
+import "net/http"
+
+// headerTransport overrides the API version and media type headers, set per
+// host with github-commit-status.<url>.apiVersion and .accept, for endpoints
+// that require or have dropped a specific version.
+type headerTransport struct {
+	base       http.RoundTripper
+	apiVersion string
+	accept     string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.apiVersion == "" && t.accept == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if t.apiVersion != "" {
+		req.Header.Set("X-GitHub-Api-Version", t.apiVersion)
+	}
+	if t.accept != "" {
+		req.Header.Set("Accept", t.accept)
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,29 @@
+package statusmark
+
+import "github.com/zalando/go-keyring"
+
+// keyringService is the name tokens are stored under in the system
+// keyring, by host.
+const keyringService = "github-commit-status-mark"
+
+// StoreToken stores token for host in the system keyring: the macOS
+// Keychain, the Secret Service on Linux or the Windows Credential Manager.
+func StoreToken(host, token string) error {
+	return keyring.Set(keyringService, host, token)
+}
+
+// DeleteToken removes the token for host from the system keyring.
+func DeleteToken(host string) error {
+	return keyring.Delete(keyringService, host)
+}
+
+// keyringToken returns the token stored for host, or "" if there is none or
+// no keyring is available.
+func keyringToken(host string) string {
+	token, err := keyring.Get(keyringService, host)
+	if err != nil {
+		return ""
+	}
+
+	return token
+}
@@ -0,0 +1,74 @@
+package statusmark
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// staleLockAge is how old a lock must be to be taken as left by a process
+// that died.
+const staleLockAge = 30 * time.Second
+
+// Lock takes the lock of the cache, a file created exclusively next to
+// it, so that of invocations looking up the same repository at once (as
+// from several shell prompts) only one asks the API. If another holds it,
+// Lock waits until it is released or ctx is done. Call unlock when done.
+func (state *Cache) Lock(ctx context.Context) (unlock func(), err error) {
+	path := filepath.Join(filepath.Dir(state.path), "lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			fmt.Fprintln(f, os.Getpid())
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for %s: %s", path, ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// Update saves state under its lock, having read it again so that what
+// others saved meanwhile is kept; f, if not nil, makes the changes to save
+// in between. What f changed is saved even if it fails, such as what was
+// learned about the host on the way. Changes made to state before are lost
+// to the reading, except for the hits and uses recorded, which are added
+// on saving.
+func (state *Cache) Update(ctx context.Context, f func() error) error {
+	unlock, err := state.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := state.Restore(); err != nil {
+		return err
+	}
+
+	if f != nil {
+		err = f()
+	}
+	if saveErr := state.Save(); err == nil {
+		err = saveErr
+	}
+
+	return err
+}
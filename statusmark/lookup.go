@@ -0,0 +1,382 @@
+package statusmark
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+const forever = time.Duration(-1)
+
+// cacheFor is how long an entry of each status stays fresh.
+var cacheFor = map[string]time.Duration{
+	StatusUnknown: 30 * time.Second,
+	StatusFailure: forever,
+	StatusPending: 10 * time.Second,
+	StatusSuccess: forever,
+	StatusWarning: forever,
+	// Broken CI setups tend to be fixed and the commit built again
+	StatusError: time.Minute,
+
+	// Waits for someone to approve or act, so check back now and then
+	StatusActionRequired: 5 * time.Minute,
+	// Waiting for a runner
+	StatusQueued: 30 * time.Second,
+	// Actually running
+	StatusInProgress: 10 * time.Second,
+	// Not pushed anywhere, so nothing could have reported; never cached
+	StatusLocal: 0,
+	// Pushed but unknown to the API; it may just not be visible yet
+	StatusNotFound: 15 * time.Second,
+}
+
+// Lookup finds the status of revisions of a repository, from the
+// cache or the API.
+type Lookup struct {
+	Repo  Repository
+	Cache *Cache
+	// Trail, if set, records how the status was decided
+	Trail *Explanation
+	// DryRun prints requests instead of sending them
+	DryRun bool
+	// Upstream looks up statuses on the parent of forked repositories
+	Upstream bool
+	// PullRequest prefers the status of the pull request containing the
+	// revision
+	PullRequest bool
+	// TokenSource, if set, authenticates in place of the configured token
+	TokenSource oauth2.TokenSource
+	// RetryMode is RetryModePrompt unless set
+	RetryMode string
+	// Context, if set, bounds the requests sent, e.g. with a timeout
+	Context context.Context
+	// Include and Exclude select the contexts to roll up on top of those
+	// selected in the settings, without affecting what is cached
+	Include, Exclude []string
+	// Required, if not empty, keeps only the contexts named, as required by
+	// branch protection, counting those not reported yet as pending
+	Required []string
+	// GraphQL asks for the statuses and check runs in one GraphQL query
+	// rather than a REST request for each, where the host supports it
+	GraphQL bool
+
+	// etags are sent and received by the clients during Fetch
+	etags map[string]string
+}
+
+// Selected returns entry rolled up from the contexts selected by Include,
+// Exclude and Required, if any.
+func (l *Lookup) Selected(entry Entry) Entry {
+	if len(l.Include) == 0 && len(l.Exclude) == 0 && len(l.Required) == 0 {
+		return entry
+	}
+	if entry.Status == StatusLocal || entry.Status == StatusNotFound {
+		return entry
+	}
+
+	if len(l.Include) > 0 || len(l.Exclude) > 0 {
+		entry.Contexts = filterContexts(entry.Contexts, l.Include, l.Exclude)
+		entry.Rule = fmt.Sprintf("roll-up of contexts matching %v and not %v", l.Include, l.Exclude)
+	}
+	if len(l.Required) > 0 {
+		names := map[string]bool{}
+		for _, name := range l.Required {
+			names[name] = true
+		}
+		var required []ContextStatus
+		for _, c := range entry.Contexts {
+			if names[c.Context] {
+				required = append(required, c)
+			}
+		}
+		entry.Contexts = addMissingContexts(required, l.Required)
+		entry.Rule = fmt.Sprintf("roll-up of the required contexts %v", l.Required)
+	}
+	entry.Status = rollupContexts(entry.Contexts, WarningContextPatterns())
+	l.Trail.Add("rule: %s gave %q", entry.Rule, entry.Status)
+
+	return entry
+}
+
+func (l *Lookup) APIClient(remote Remote) *github.Client {
+	mode := l.RetryMode
+	if mode == "" {
+		mode = RetryModePrompt
+	}
+
+	return NewAPIClient(remote.URL, ClientOptions{
+		RetryPolicy: LoadRetryPolicy(mode),
+		TokenSource: l.TokenSource,
+		APICalls:    &l.Cache.Stats.APICalls,
+		Trail:       l.Trail,
+		DryRun:      l.DryRun,
+		ETags:       l.etags,
+		RateLimits:  l.Cache.rateLimits(),
+		Context:     l.Context,
+		APIBases:    l.Cache.apiBases(),
+	})
+}
+
+// cacheKey returns the key of the entry of rev, which is apart for the
+// statuses of the upstream, as they are not those of the remote itself.
+func (l *Lookup) cacheKey(rev string) string {
+	if l.Upstream {
+		return "upstream/" + rev
+	}
+
+	return rev
+}
+
+// Cached returns the cached entry for rev and whether it is still fresh.
+// Entries kept forever expire after github-commit-status.revalidateAfter
+// if set, e.g. to 1h, to be fetched again with conditional requests.
+func (l *Lookup) Cached(rev string) (Entry, bool) {
+	entry, ok := l.Cache.Revisions[l.cacheKey(rev)]
+	if !ok {
+		l.Trail.Add("cache: no entry for %s", rev)
+		return entry, false
+	}
+	l.Cache.touch(l.cacheKey(rev))
+
+	ttl := statusCacheFor(entry.Status)
+
+	age := time.Since(time.Unix(entry.LastModified, 0)).Round(time.Second)
+	if ttl == forever {
+		// Checks are re-run, so a final status may be asked for again now and
+		// then, which costs little with the ETags of the entry
+		if revalidate := ConfigDuration("revalidateAfter", 0); revalidate > 0 && age >= revalidate {
+			l.Trail.Add("cache: %q entry from %s ago, revalidated after %s", entry.Status, age, revalidate)
+			return entry, false
+		}
+
+		l.Trail.Add("cache: %q entry from %s ago, kept forever", entry.Status, age)
+		return entry, true
+	}
+
+	fresh := age < ttl
+	if fresh {
+		l.Trail.Add("cache: %q entry from %s ago, fresh for %s", entry.Status, age, ttl)
+	} else {
+		l.Trail.Add("cache: %q entry from %s ago, expired after %s", entry.Status, age, ttl)
+	}
+
+	if host, limit, low := l.Cache.rateLimited(); !fresh && low {
+		l.Trail.Add("rate limit: %d of %d requests left on %s until %s, so the expired entry is kept", limit.Remaining, limit.Limit, host, limit.ResetTime().Format("15:04:05"))
+		return entry, true
+	}
+
+	return entry, fresh
+}
+
+// Fetch asks the API for the status of rev, trying each configured remote
+// until one knows the commit, and stores the result in the cache. Commits
+// that have not been pushed are reported as such without asking. The
+// requests are conditional on the ETags of the cached entry, if any, so
+// that an unchanged status only has its entry refreshed. With GraphQL, a
+// single query is tried first, which cannot be conditional.
+func (l *Lookup) Fetch(rev string) (Entry, Remote, *github.Client, error) {
+	if !l.Repo.IsPushed(rev) {
+		l.Trail.Add("rule: %s is not on any remote-tracking branch, so the API was not asked", rev)
+		return Entry{Status: StatusLocal, Rule: "not pushed"}, Remote{}, nil, nil
+	}
+
+	first, err := ParseRemote(l.Repo, ConfiguredRemotes(l.Repo)[0])
+	if err != nil {
+		return Entry{}, first, nil, err
+	}
+	if p, ok := providers[ProviderOf(first.URL)]; ok {
+		entry, remote, err := l.fetchFrom(p, rev)
+		return entry, remote, nil, err
+	}
+
+	if l.GraphQL {
+		entry, remote, client, ok, err := l.fetchRollup(rev)
+		if ok || err != nil {
+			return entry, remote, client, err
+		}
+	}
+
+	return l.fetch(rev, true)
+}
+
+func (l *Lookup) fetch(rev string, conditional bool) (Entry, Remote, *github.Client, error) {
+	prev, hasPrev := l.Cache.Revisions[l.cacheKey(rev)]
+	l.etags = map[string]string{}
+	if conditional && hasPrev {
+		for url, etag := range prev.ETags {
+			l.etags[url] = etag
+		}
+	}
+	defer func() { l.etags = nil }()
+
+	var (
+		remote    Remote
+		client    *github.Client
+		statuses  []github.RepoStatus
+		err       error
+		runs      []CheckRun
+		checked   bool
+		checksErr error
+	)
+
+	fetchStart := time.Now()
+	for _, name := range ConfiguredRemotes(l.Repo) {
+		if remote, err = ParseRemote(l.Repo, name); err != nil {
+			return Entry{}, remote, nil, err
+		}
+		l.Trail.Add("remote: %s (%s/%s on %s)", name, remote.Owner, remote.Name, remote.URL.Host)
+
+		client = l.APIClient(remote)
+		if err := RequireFeature(l.Cache.HostInfo(client, remote), remote, FeatureStatuses); err != nil {
+			return Entry{}, remote, client, err
+		}
+
+		if l.Upstream {
+			if upstream := l.Cache.upstreamOf(client, remote); upstream != remote {
+				remote = upstream
+				l.Trail.Add("remote: using upstream %s/%s", remote.Owner, remote.Name)
+			}
+		}
+
+		// GitHub Actions and other apps report check runs instead of
+		// statuses, so both are asked for at once
+		var wg sync.WaitGroup
+		checked = l.Cache.HostInfo(client, remote).Supports(FeatureChecks)
+		if checked {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runs, checksErr = ListCheckRuns(client, remote, rev)
+			}()
+		}
+
+		// Rather than the combined status, as that lacks who created the
+		// statuses, which roll-up scripts may want
+		statuses, _, err = client.Repositories.ListStatuses(remote.Owner, remote.Name, rev, &github.ListOptions{PerPage: 100})
+		wg.Wait()
+		if !isNotFound(err) {
+			break
+		}
+		l.Trail.Add("remote: %s does not know %s", name, rev)
+		runs, checked = nil, false
+	}
+	l.Cache.Stats.recordFetch(time.Since(fetchStart))
+
+	statusesNotModified := isNotModified(err)
+	if statusesNotModified {
+		err = nil
+	}
+	if err != nil && !isNotFound(err) {
+		return Entry{}, remote, client, fmt.Errorf("Error while fetching status: %s", err)
+	}
+
+	checksNotModified := checked && isNotModified(checksErr)
+	if checked && checksErr != nil && !checksNotModified {
+		l.Trail.Add("checks: could not list check runs: %s", checksErr)
+	}
+
+	if statusesNotModified && (checksNotModified || !checked) {
+		l.Trail.Add("cache: statuses of %s not modified since the %q entry", rev, prev.Status)
+		prev.LastModified = time.Now().Unix()
+		l.Cache.Revisions[l.cacheKey(rev)] = prev
+		return prev, remote, client, nil
+	}
+	if statusesNotModified || checksNotModified {
+		// Only what has changed came back, so ask for all of it again
+		l.Trail.Add("cache: statuses of %s partly modified; fetching them again", rev)
+		return l.fetch(rev, false)
+	}
+
+	contexts, filtered := applyContextSettings(append(LatestContexts(statuses), checkRunContexts(runs)...))
+
+	entry := Entry{
+		Status:       StatusUnknown,
+		Contexts:     contexts,
+		LastModified: time.Now().Unix(),
+	}
+
+	if isNotFound(err) {
+		entry.Rule = fmt.Sprintf("commit not found on %s; not visible yet, or the wrong repository", strings.Join(ConfiguredRemotes(l.Repo), ", "))
+		entry.Status = StatusNotFound
+		l.Trail.Add("rule: %s gave %q", entry.Rule, entry.Status)
+	} else if err := l.rollUp(&entry, filtered, rev); err != nil {
+		return Entry{}, remote, client, err
+	}
+
+	if l.PullRequest && err == nil && l.Cache.HostInfo(client, remote).Supports(FeatureGraphQL) {
+		l.preferPullRequest(&entry, client, remote, rev)
+	}
+
+	if l.Cache.Revisions == nil {
+		l.Cache.Revisions = map[string]Entry{}
+	}
+	entry.ETags = map[string]string{}
+	for url, etag := range l.etags {
+		if strings.Contains(url, rev) {
+			entry.ETags[url] = etag
+		}
+	}
+
+	l.Cache.Revisions[l.cacheKey(rev)] = entry
+
+	return entry, remote, client, nil
+}
+
+// rollUp decides the status of entry from its contexts by the roll-up
+// script, the warning-only contexts or else the states of all of them.
+// filtered tells whether the contexts were selected by the settings.
+func (l *Lookup) rollUp(entry *Entry, filtered bool, rev string) error {
+	for _, c := range entry.Contexts {
+		l.Trail.Add("context: %s is %q", c.Context, c.State)
+	}
+
+	var err error
+	if script := ConfigValue("rollupScript"); script != "" {
+		entry.Rule = fmt.Sprintf("roll-up script %s", script)
+		entry.Status, err = runRollupScript(script, entry.Contexts, l.Repo.Branch(), rev)
+		if err != nil {
+			return fmt.Errorf("Error in roll-up script: %s", err)
+		}
+	} else if patterns := WarningContextPatterns(); len(patterns) > 0 {
+		entry.Rule = fmt.Sprintf("roll-up with warning-only contexts %v", patterns)
+		entry.Status = rollupContexts(entry.Contexts, patterns)
+	} else if filtered {
+		entry.Rule = "roll-up of required and not ignored contexts"
+		entry.Status = rollupContexts(entry.Contexts, nil)
+	} else if len(entry.Contexts) > 0 {
+		entry.Rule = "roll-up of the latest status of every context and check run"
+		entry.Status = rollupContexts(entry.Contexts, nil)
+	} else {
+		entry.Rule = "no statuses or check runs reported"
+	}
+	l.Trail.Add("rule: %s gave %q", entry.Rule, entry.Status)
+
+	return nil
+}
+
+// preferPullRequest replaces the status and contexts of entry with the
+// roll-up of the head of the pull request containing rev, if there is one
+// that has any checks. The rule tells which commit that is.
+func (l *Lookup) preferPullRequest(entry *Entry, client *github.Client, remote Remote, rev string) {
+	pull, err := associatedPullRequest(client, remote, rev)
+	if err != nil || pull == nil {
+		l.Trail.Add("pull request: none found for %s", rev)
+		return
+	}
+
+	state, contexts, head, err := pullRequestRollup(client, remote, pull.Number)
+	if err != nil || state == "" {
+		l.Trail.Add("pull request: #%d has no status check roll-up", pull.Number)
+		return
+	}
+
+	entry.Rule = fmt.Sprintf("status check roll-up of %s, the head of pull request #%d", head, pull.Number)
+	entry.Status = state
+	entry.Contexts = contexts
+	l.Trail.Add("rule: %s gave %q", entry.Rule, entry.Status)
+}
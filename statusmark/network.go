@@ -0,0 +1,31 @@
+package statusmark
+
+import "net"
+
+// NetworkDown reports whether there is evidently no network: no interface
+// other than loopback is up with an address to reach the API from. It
+// cannot tell a network that is up but cut off, which -timeout is for.
+func NetworkDown() bool {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+				return false
+			}
+		}
+	}
+
+	return true
+}
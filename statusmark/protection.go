@@ -0,0 +1,78 @@
+package statusmark
+
+import (
+	"fmt"
+	"time"
+)
+
+// protectionCacheFor is how long the required contexts of a branch are
+// cached; branch protection rarely changes.
+const protectionCacheFor = time.Hour
+
+// ProtectionEntry is what the branch protection of a branch requires.
+type ProtectionEntry struct {
+	Contexts     []string
+	LastModified int64
+}
+
+// RequiredContexts returns the status contexts and check runs the branch
+// protection of branch requires to pass, on the first remote or its
+// upstream if looking there. It is empty if the branch is not protected.
+func (l *Lookup) RequiredContexts(branch string) ([]string, error) {
+	remote, err := ParseRemote(l.Repo, ConfiguredRemotes(l.Repo)[0])
+	if err != nil {
+		return nil, err
+	}
+	client := l.APIClient(remote)
+	if l.Upstream {
+		remote = l.Cache.upstreamOf(client, remote)
+	}
+
+	key := remote.URL.Host + "/" + remote.Owner + "/" + remote.Name + "/" + branch
+	if entry, ok := l.Cache.Protections[key]; ok && time.Since(time.Unix(entry.LastModified, 0)) < protectionCacheFor {
+		return entry.Contexts, nil
+	}
+
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/branches/%s", remote.Owner, remote.Name, branch), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var b struct {
+		Protection struct {
+			RequiredStatusChecks struct {
+				Contexts []string `json:"contexts"`
+				Checks   []struct {
+					Context string `json:"context"`
+				} `json:"checks"`
+			} `json:"required_status_checks"`
+		} `json:"protection"`
+	}
+	if _, err := client.Do(req, &b); err != nil {
+		return nil, err
+	}
+
+	// checks repeats contexts with the app expected to report each
+	contexts := []string{}
+	seen := map[string]bool{}
+	for _, c := range b.Protection.RequiredStatusChecks.Contexts {
+		if !seen[c] {
+			seen[c] = true
+			contexts = append(contexts, c)
+		}
+	}
+	for _, c := range b.Protection.RequiredStatusChecks.Checks {
+		if !seen[c.Context] {
+			seen[c.Context] = true
+			contexts = append(contexts, c.Context)
+		}
+	}
+
+	if l.Cache.Protections == nil {
+		l.Cache.Protections = map[string]ProtectionEntry{}
+	}
+	l.Cache.Protections[key] = ProtectionEntry{Contexts: contexts, LastModified: time.Now().Unix()}
+	l.Trail.Add("protection: %s on %s/%s requires %v", branch, remote.Owner, remote.Name, contexts)
+
+	return contexts, nil
+}
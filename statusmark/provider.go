@@ -0,0 +1,168 @@
+package statusmark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+	ProviderGitea  = "gitea"
+	// Forgejo keeps the API of Gitea
+	ProviderForgejo = "forgejo"
+	// Bitbucket Cloud; Bitbucket Data Center has another API
+	ProviderBitbucket = "bitbucket"
+)
+
+// provider is a code host other than GitHub that statuses are looked up on.
+// GitHub itself is asked by Lookup directly, as most of what this tool does
+// is particular to it.
+type provider interface {
+	// apiBase returns the usual root of the API of the host of remoteURL.
+	apiBase(remoteURL *url.URL) *url.URL
+	// contexts returns the latest status of every context reported for sha
+	// on remote, or errCommitNotFound.
+	contexts(client *http.Client, base *url.URL, remote Remote, sha string) ([]ContextStatus, error)
+}
+
+// basicAuthProvider is a provider that takes a user name and password, if
+// there are any for the host of remoteURL, over a token.
+type basicAuthProvider interface {
+	basicAuth(remoteURL *url.URL) *url.Userinfo
+}
+
+var providers = map[string]provider{
+	ProviderGitLab:    gitLab{},
+	ProviderGitea:     gitea{},
+	ProviderForgejo:   gitea{},
+	ProviderBitbucket: bitbucket{},
+}
+
+// providerHosts are the hosts whose provider is known without settings.
+var providerHosts = map[string]string{
+	"gitlab.com":    ProviderGitLab,
+	"gitea.com":     ProviderGitea,
+	"codeberg.org":  ProviderForgejo,
+	"bitbucket.org": ProviderBitbucket,
+}
+
+var errCommitNotFound = errors.New("commit not found")
+
+// ProviderOf returns the provider of the host of remoteURL, from
+// github-commit-status.provider, which may be URL-specific as in
+// github-commit-status.https://gitlab.example.com.provider, or else by
+// well-known hosts, or else ProviderGitHub.
+func ProviderOf(remoteURL *url.URL) string {
+	if p := configURLValue("provider", remoteURL); p != "" {
+		return p
+	}
+	if p, ok := providerHosts[remoteURL.Host]; ok {
+		return p
+	}
+
+	return ProviderGitHub
+}
+
+// getJSON decodes the response to GET u into out, taking 404 for
+// errCommitNotFound.
+func getJSON(client *http.Client, u string, out interface{}) error {
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errCommitNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// fetchFrom asks p for the status of rev, trying each configured remote
+// until one knows the commit. The GitHub settings of upstreams and pull
+// requests do not apply, nor do conditional requests.
+func (l *Lookup) fetchFrom(p provider, rev string) (Entry, Remote, error) {
+	var (
+		remote   Remote
+		contexts []ContextStatus
+		err      error
+	)
+
+	fetchStart := time.Now()
+	for _, name := range ConfiguredRemotes(l.Repo) {
+		if remote, err = ParseRemote(l.Repo, name); err != nil {
+			return Entry{}, remote, err
+		}
+		l.Trail.Add("remote: %s (%s on %s, %s)", name, strings.TrimPrefix(remote.URL.Path, "/"), remote.URL.Host, ProviderOf(remote.URL))
+
+		base := p.apiBase(remote.URL)
+		if configured := configuredAPIBase(remote.URL); configured != "" {
+			if base, err = parseAPIBase(configured); err != nil {
+				break
+			}
+		}
+		contexts, err = p.contexts(l.httpClient(remote, p), base, remote, rev)
+		if err != errCommitNotFound {
+			break
+		}
+		l.Trail.Add("remote: %s does not know %s", name, rev)
+	}
+	l.Cache.Stats.recordFetch(time.Since(fetchStart))
+	if err != nil && err != errCommitNotFound {
+		return Entry{}, remote, fmt.Errorf("Error while fetching status: %s", err)
+	}
+
+	entry := Entry{
+		Status:       StatusUnknown,
+		LastModified: time.Now().Unix(),
+	}
+	if err == errCommitNotFound {
+		entry.Rule = fmt.Sprintf("commit not found on %s; not visible yet, or the wrong repository", strings.Join(ConfiguredRemotes(l.Repo), ", "))
+		entry.Status = StatusNotFound
+		l.Trail.Add("rule: %s gave %q", entry.Rule, entry.Status)
+	} else {
+		var filtered bool
+		entry.Contexts, filtered = applyContextSettings(contexts)
+		if err := l.rollUp(&entry, filtered, rev); err != nil {
+			return Entry{}, remote, err
+		}
+	}
+
+	if l.Cache.Revisions == nil {
+		l.Cache.Revisions = map[string]Entry{}
+	}
+	l.Cache.Revisions[l.cacheKey(rev)] = entry
+
+	return entry, remote, nil
+}
+
+func (l *Lookup) httpClient(remote Remote, p provider) *http.Client {
+	mode := l.RetryMode
+	if mode == "" {
+		mode = RetryModePrompt
+	}
+
+	opts := ClientOptions{
+		RetryPolicy: LoadRetryPolicy(mode),
+		TokenSource: l.TokenSource,
+		APICalls:    &l.Cache.Stats.APICalls,
+		Trail:       l.Trail,
+		DryRun:      l.DryRun,
+		Context:     l.Context,
+	}
+	if p, ok := p.(basicAuthProvider); ok && l.TokenSource == nil {
+		opts.BasicAuth = p.basicAuth(remote.URL)
+	}
+
+	return newHTTPClient(remote.URL, opts)
+}
@@ -0,0 +1,220 @@
+package statusmark
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+type pullRequest struct {
+	Number int    `json:"number"`
+	State  string `json:"state"`
+}
+
+// associatedPullRequest returns the pull request containing rev, preferring
+// an open one, or nil if there is none.
+func associatedPullRequest(client *github.Client, remote Remote, rev string) (*pullRequest, error) {
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/commits/%s/pulls", remote.Owner, remote.Name, rev), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var pulls []pullRequest
+	if _, err := client.Do(req, &pulls); err != nil {
+		return nil, err
+	}
+
+	for i := range pulls {
+		if pulls[i].State == "open" {
+			return &pulls[i], nil
+		}
+	}
+	if len(pulls) > 0 {
+		return &pulls[0], nil
+	}
+
+	return nil, nil
+}
+
+const pullRequestRollupQuery = `
+query($owner: String!, $name: String!, $number: Int!) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      commits(last: 1) {
+        nodes {
+          commit {
+            oid
+            statusCheckRollup {
+              state` + rollupContextsSelection + `
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// pullRequestRollup returns the state and contexts of the statusCheckRollup
+// of the head commit of a pull request, the last of its commits, which need
+// not be the one asked about, and its SHA. Checks run for the pull request
+// report to its head, even when they build its merge commit. The state is
+// empty if nothing has reported.
+func pullRequestRollup(client *github.Client, remote Remote, number int) (state string, contexts []ContextStatus, head string, err error) {
+	var data struct {
+		Repository struct {
+			PullRequest struct {
+				Commits struct {
+					Nodes []struct {
+						Commit struct {
+							OID               string `json:"oid"`
+							StatusCheckRollup *struct {
+								State    string `json:"state"`
+								Contexts struct {
+									Nodes []rollupNode `json:"nodes"`
+								} `json:"contexts"`
+							} `json:"statusCheckRollup"`
+						} `json:"commit"`
+					} `json:"nodes"`
+				} `json:"commits"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	}
+
+	err = graphQL(client, pullRequestRollupQuery, map[string]interface{}{
+		"owner":  remote.Owner,
+		"name":   remote.Name,
+		"number": number,
+	}, &data)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	nodes := data.Repository.PullRequest.Commits.Nodes
+	if len(nodes) == 0 || nodes[0].Commit.StatusCheckRollup == nil {
+		return "", nil, "", nil
+	}
+
+	commit := nodes[0].Commit
+	return rollupState(commit.StatusCheckRollup.State), rollupNodeContexts(commit.StatusCheckRollup.Contexts.Nodes), commit.OID, nil
+}
+
+// rollupState maps a GraphQL StatusState onto the states marks are
+// configured for.
+func rollupState(state string) string {
+	if state == "EXPECTED" {
+		return StatusPending
+	}
+
+	return strings.ToLower(state)
+}
+
+// PullRequest is the open pull request of a branch, as found by
+// OpenPullRequest.
+type PullRequest struct {
+	Number         int
+	HeadSHA        string
+	MergeCommitSHA string
+	// base is the repository the pull request is made to
+	base Remote
+}
+
+// Key returns the cache key of the pull request's status, which changes
+// with its head.
+func (pull *PullRequest) Key() string {
+	return fmt.Sprintf("pull/%d/%s", pull.Number, pull.HeadSHA)
+}
+
+// OpenPullRequest returns the open pull request made from branch of the
+// first remote, to the repository itself or its upstream if looking there,
+// or nil if there is none.
+func (l *Lookup) OpenPullRequest(branch string) (*PullRequest, error) {
+	remote, err := ParseRemote(l.Repo, ConfiguredRemotes(l.Repo)[0])
+	if err != nil {
+		return nil, err
+	}
+	client := l.APIClient(remote)
+
+	base := remote
+	if l.Upstream {
+		base = l.Cache.upstreamOf(client, remote)
+	}
+
+	path := fmt.Sprintf("repos/%s/%s/pulls?state=open&head=%s", base.Owner, base.Name, url.QueryEscape(remote.Owner+":"+branch))
+	req, err := client.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var pulls []struct {
+		Number int `json:"number"`
+		Head   struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+		MergeCommitSHA string `json:"merge_commit_sha"`
+	}
+	if _, err := client.Do(req, &pulls); err != nil {
+		return nil, err
+	}
+	if len(pulls) == 0 {
+		return nil, nil
+	}
+
+	l.Trail.Add("pull request: #%d on %s/%s is open for %s", pulls[0].Number, base.Owner, base.Name, branch)
+	return &PullRequest{
+		Number:         pulls[0].Number,
+		HeadSHA:        pulls[0].Head.SHA,
+		MergeCommitSHA: pulls[0].MergeCommitSHA,
+		base:           base,
+	}, nil
+}
+
+// FetchPullRequest asks the API for the statuses and check runs of the head
+// of pull and of its test merge commit, rolls them up together as they
+// gate merging, and stores the result in the cache as that of pull.Key().
+func (l *Lookup) FetchPullRequest(pull *PullRequest) (Entry, error) {
+	client := l.APIClient(pull.base)
+
+	fetchStart := time.Now()
+	var contexts []ContextStatus
+	for _, sha := range []string{pull.HeadSHA, pull.MergeCommitSHA} {
+		if sha == "" {
+			// Not computed yet, or the pull request conflicts
+			continue
+		}
+
+		statuses, _, err := client.Repositories.ListStatuses(pull.base.Owner, pull.base.Name, sha, &github.ListOptions{PerPage: 100})
+		if err != nil && !isNotFound(err) {
+			return Entry{}, fmt.Errorf("Error while fetching status: %s", err)
+		}
+		runs, err := ListCheckRuns(client, pull.base, sha)
+		if err != nil {
+			l.Trail.Add("checks: could not list check runs of %s: %s", sha, err)
+		}
+
+		contexts = append(contexts, LatestContexts(statuses)...)
+		contexts = append(contexts, checkRunContexts(runs)...)
+	}
+	l.Cache.Stats.recordFetch(time.Since(fetchStart))
+
+	contexts, _ = applyContextSettings(contexts)
+	entry := Entry{
+		Status:       rollupContexts(contexts, WarningContextPatterns()),
+		Contexts:     contexts,
+		Rule:         fmt.Sprintf("roll-up of the head and merge commit of pull request #%d", pull.Number),
+		LastModified: time.Now().Unix(),
+	}
+	for _, c := range entry.Contexts {
+		l.Trail.Add("context: %s is %q", c.Context, c.State)
+	}
+	l.Trail.Add("rule: %s gave %q", entry.Rule, entry.Status)
+
+	if l.Cache.Revisions == nil {
+		l.Cache.Revisions = map[string]Entry{}
+	}
+	l.Cache.Revisions[l.cacheKey(pull.Key())] = entry
+
+	return entry, nil
+}
@@ -0,0 +1,124 @@
+package statusmark
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is the API budget of a host as of its last response.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	// Reset is when the budget is restored, in Unix time
+	Reset int64
+}
+
+// ResetTime returns when the budget is restored.
+func (r RateLimit) ResetTime() time.Time {
+	return time.Unix(r.Reset, 0)
+}
+
+// Low reports whether the budget is nearly exhausted: fewer requests are
+// left until reset than github-commit-status.rateLimitReserve, 5% of the
+// limit by default.
+func (r RateLimit) Low() bool {
+	if r.Limit == 0 || !time.Now().Before(r.ResetTime()) {
+		return false
+	}
+
+	return r.Remaining < ConfigInt("rateLimitReserve", r.Limit/20)
+}
+
+// rateLimitTransport records the budget each response reports in its
+// X-RateLimit-* headers as that of host.
+type rateLimitTransport struct {
+	base   http.RoundTripper
+	host   string
+	mu     sync.Mutex
+	limits map[string]RateLimit
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if limit, ok := parseRateLimit(resp.Header); ok {
+		t.mu.Lock()
+		t.limits[t.host] = limit
+		t.mu.Unlock()
+	}
+
+	return resp, nil
+}
+
+func parseRateLimit(header http.Header) (RateLimit, bool) {
+	var (
+		limit RateLimit
+		err   error
+	)
+	if limit.Limit, err = strconv.Atoi(header.Get("X-RateLimit-Limit")); err != nil {
+		return limit, false
+	}
+	if limit.Remaining, err = strconv.Atoi(header.Get("X-RateLimit-Remaining")); err != nil {
+		return limit, false
+	}
+	if limit.Reset, err = strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err != nil {
+		return limit, false
+	}
+
+	return limit, true
+}
+
+func (state *Cache) rateLimits() map[string]RateLimit {
+	if state.RateLimits == nil {
+		state.RateLimits = map[string]RateLimit{}
+	}
+
+	return state.RateLimits
+}
+
+// rateLimited returns the budget of a host nearly exhausted, if any, so
+// that expired entries are served until it is restored rather than
+// spending what is left.
+func (state *Cache) rateLimited() (string, RateLimit, bool) {
+	for host, limit := range state.RateLimits {
+		if limit.Low() {
+			return host, limit, true
+		}
+	}
+
+	return "", RateLimit{}, false
+}
+
+// FetchRateLimit asks the API host of remote for the current budget of
+// the core API, which costs none of it. Hosts with rate limiting disabled
+// have none.
+func (l *Lookup) FetchRateLimit(remote Remote) (*RateLimit, error) {
+	client := l.APIClient(remote)
+
+	req, err := client.NewRequest("GET", "rate_limit", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Resources struct {
+			Core RateLimit
+		}
+	}
+	if _, err := client.Do(req, &result); err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	limit := result.Resources.Core
+	l.Cache.rateLimits()[remote.URL.Host] = limit
+
+	return &limit, nil
+}
@@ -0,0 +1,284 @@
+package statusmark
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+var reScheme = regexp.MustCompile(`^[\w+.-]+://`)
+
+// NormalizeURL returns the https URL of the repository at the git remote
+// URL urlString, without user, .git suffix or trailing slash. It takes
+//
+//	https://[user@]host[:port]/path and http://..., keeping the port
+//	ssh://[user@]host[:port]/path and git://..., dropping the port, which is
+//	not that of the web server
+//	[user@]host:path, scp-like
+//
+// Local paths come back without a host.
+func NormalizeURL(urlString string) (*url.URL, error) {
+	s := strings.TrimSpace(urlString)
+
+	var u *url.URL
+	if reScheme.MatchString(s) {
+		var err error
+		u, err = url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			if port := u.Port(); port != "" {
+				u.Host = strings.TrimSuffix(u.Host, ":"+port)
+			}
+		}
+	} else {
+		// scp-like, unless a slash comes before the colon as in local paths;
+		// hosts may be IPv6 addresses in brackets
+		colon := strings.Index(s, ":")
+		if i := strings.Index(s, "]:"); i >= 0 {
+			colon = i + 1
+		}
+		if colon <= 0 || strings.Contains(s[:colon], "/") {
+			return &url.URL{Path: s}, nil
+		}
+
+		host := s[:colon]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		u = &url.URL{Host: host, Path: s[colon+1:]}
+	}
+
+	u.Scheme = "https"
+	u.User = nil
+	u.Path = "/" + strings.Trim(strings.TrimSuffix(strings.TrimRight(u.Path, "/"), ".git"), "/")
+	u.RawPath, u.RawQuery, u.Fragment = "", "", ""
+
+	return u, nil
+}
+
+type Remote struct {
+	URL   *url.URL
+	Owner string
+	Name  string
+}
+
+// ParseRemoteURL returns the repository at the git remote URL rawURL. Its
+// owner and name are the last two parts of the path, as GitHub Enterprise
+// may be served under a path of its host.
+func ParseRemoteURL(rawURL string) (Remote, error) {
+	remoteURL, err := NormalizeURL(rawURL)
+	if err != nil {
+		return Remote{}, err
+	}
+
+	parts := strings.Split(strings.TrimPrefix(remoteURL.Path, "/"), "/")
+	if remoteURL.Host == "" || len(parts) < 2 || parts[len(parts)-2] == "" || parts[len(parts)-1] == "" {
+		return Remote{}, fmt.Errorf("not a repository on a host: %q", rawURL)
+	}
+
+	return Remote{
+		URL:   remoteURL,
+		Owner: parts[len(parts)-2],
+		Name:  parts[len(parts)-1],
+	}, nil
+}
+
+func ParseRemote(repo Repository, name string) (Remote, error) {
+	rawURL, err := repo.RemoteURL(name)
+	if err != nil {
+		return Remote{}, err
+	}
+
+	remote, err := ParseRemoteURL(rawURL)
+	if err != nil {
+		return Remote{}, fmt.Errorf("Could not parse the URL of remote %q: %s", name, err)
+	}
+
+	return remote, nil
+}
+
+// remoteOnlyRepository is a repository on GitHub known only by its URL, for
+// looking up commits without a local clone or git at all.
+type remoteOnlyRepository struct {
+	url *url.URL
+}
+
+// RemoteOnlyRepository returns the repository given as owner/name on
+// github.com, host/owner/name or a URL. Every remote stands for it.
+func RemoteOnlyRepository(repo string) (Repository, error) {
+	if !reScheme.MatchString(repo) {
+		switch strings.Count(repo, "/") {
+		case 1:
+			repo = "https://github.com/" + repo
+		case 2:
+			// Not scp-like, so that the host may have a port
+			repo = "https://" + repo
+		}
+	}
+
+	remote, err := ParseRemoteURL(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return remoteOnlyRepository{url: remote.URL}, nil
+}
+
+// Resolve takes rev as is, as only the API can resolve it.
+func (r remoteOnlyRepository) Resolve(rev string) (string, string, error) {
+	toplevel, _ := r.Toplevel()
+	return toplevel, rev, nil
+}
+
+// Toplevel returns a path standing in for a work tree: under the user's
+// cache directory, by host and repository.
+func (r remoteOnlyRepository) Toplevel() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "github-commit-status-mark", "repos", r.url.Host, filepath.FromSlash(r.url.Path)), nil
+}
+
+func (r remoteOnlyRepository) CommonDir() (string, error) {
+	return r.Toplevel()
+}
+
+func (r remoteOnlyRepository) RemoteURL(name string) (string, error) {
+	return r.url.String(), nil
+}
+
+func (r remoteOnlyRepository) Branch() string {
+	return ""
+}
+
+func (r remoteOnlyRepository) BranchRemote() string {
+	return ""
+}
+
+func (r remoteOnlyRepository) IsPushed(sha string) bool {
+	return true
+}
+
+// RemoteName is -remote, taking precedence over github-commit-status.remotes.
+var RemoteName string
+
+// ConfiguredRemotes returns the remotes of repo to query in order, from
+// -remote or the space-separated github-commit-status.remotes. Repositories
+// mirrored across hosts can list every mirror so a commit missing on one is
+// looked up on the next.
+//
+// Unless set, they are origin and then the remote the current branch tracks,
+// as in triangular workflows where origin is a fork, or not there at all.
+// Remotes that are not on a host, such as local paths, are left out.
+func ConfiguredRemotes(repo Repository) []string {
+	if RemoteName != "" {
+		return []string{RemoteName}
+	}
+	if remotes := strings.Fields(ConfigValue("remotes")); len(remotes) > 0 {
+		return remotes
+	}
+
+	var remotes []string
+	for _, name := range []string{"origin", repo.BranchRemote()} {
+		if name == "" || len(remotes) > 0 && remotes[0] == name || !isHostedRemote(repo, name) {
+			continue
+		}
+		remotes = append(remotes, name)
+	}
+	if len(remotes) == 0 {
+		// For the errors to name it
+		return []string{"origin"}
+	}
+
+	return remotes
+}
+
+// isHostedRemote reports whether the remote called name exists and its URL
+// names a repository on a host.
+func isHostedRemote(repo Repository, name string) bool {
+	_, err := ParseRemote(repo, name)
+	return err == nil
+}
+
+func isNotFound(err error) bool {
+	errResp, ok := err.(*github.ErrorResponse)
+	return ok && errResp.Response != nil && errResp.Response.StatusCode == 404
+}
+
+type repositoryInfo struct {
+	Fork   bool `json:"fork"`
+	Parent *struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"parent"`
+}
+
+// upstreamOf returns the repository remote was forked from, or remote itself
+// if it is not a fork. Forks get their statuses reported upstream when
+// checks run for pull requests there.
+func (state *Cache) upstreamOf(client *github.Client, remote Remote) Remote {
+	key := remote.URL.Host + "/" + remote.Owner + "/" + remote.Name
+
+	if parent, ok := state.Upstreams[key]; ok {
+		if parent == "" {
+			return remote
+		}
+		parts := strings.SplitN(parent, "/", 2)
+		remote.Owner, remote.Name = parts[0], parts[1]
+		return remote
+	}
+
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s", remote.Owner, remote.Name), nil)
+	if err != nil {
+		return remote
+	}
+
+	var info repositoryInfo
+	if _, err := client.Do(req, &info); err != nil {
+		return remote
+	}
+
+	if state.Upstreams == nil {
+		state.Upstreams = map[string]string{}
+	}
+
+	if !info.Fork || info.Parent == nil {
+		state.Upstreams[key] = ""
+		return remote
+	}
+
+	state.Upstreams[key] = info.Parent.Owner.Login + "/" + info.Parent.Name
+	remote.Owner, remote.Name = info.Parent.Owner.Login, info.Parent.Name
+
+	return remote
+}
+
+// BranchHead returns the commit the branch currently points to on remote.
+func BranchHead(client *github.Client, remote Remote, branch string) (string, error) {
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/branches/%s", remote.Owner, remote.Name, branch), nil)
+	if err != nil {
+		return "", err
+	}
+
+	var b struct {
+		Commit struct {
+			SHA string `json:"sha"`
+		} `json:"commit"`
+	}
+	if _, err := client.Do(req, &b); err != nil {
+		return "", err
+	}
+
+	return b.Commit.SHA, nil
+}
@@ -0,0 +1,56 @@
+package statusmark
+
+import "time"
+
+// Status is the status of a revision as a document for other programs, the
+// output of -json and the input of -query.
+type Status struct {
+	Revision  string          `json:"revision"`
+	State     string          `json:"status"`
+	Rule      string          `json:"rule,omitempty"`
+	Cached    bool            `json:"cached"`
+	FetchedAt *time.Time      `json:"fetched_at,omitempty"`
+	Contexts  []StatusContext `json:"contexts"`
+}
+
+type StatusContext struct {
+	Name        string     `json:"name"`
+	State       string     `json:"state"`
+	Description string     `json:"description,omitempty"`
+	TargetURL   string     `json:"target_url,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+func NewStatus(entry Entry, rev string, cached bool) Status {
+	r := Status{
+		Revision: rev,
+		State:    entry.Status,
+		Rule:     entry.Rule,
+		Cached:   cached,
+		Contexts: []StatusContext{},
+	}
+	if r.State == StatusUnknown {
+		r.State = "unknown"
+	}
+	if entry.LastModified != 0 {
+		t := time.Unix(entry.LastModified, 0)
+		r.FetchedAt = &t
+	}
+
+	for _, c := range entry.Contexts {
+		rc := StatusContext{
+			Name:        c.Context,
+			State:       c.State,
+			Description: c.Description,
+			TargetURL:   c.TargetURL,
+		}
+		if !c.UpdatedAt.IsZero() {
+			updatedAt := c.UpdatedAt
+			rc.UpdatedAt = &updatedAt
+		}
+
+		r.Contexts = append(r.Contexts, rc)
+	}
+
+	return r
+}
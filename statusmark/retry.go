@@ -0,0 +1,133 @@
+package statusmark
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RetryModePrompt = "prompt"
+	RetryModeWatch  = "watch"
+)
+
+const initialRetryDelay = 100 * time.Millisecond
+
+type RetryPolicy struct {
+	maxAttempts int
+	statusCodes map[int]bool
+	maxDelay    time.Duration
+}
+
+// Prompt invocations must return quickly, while watching can afford to wait
+// out a flaky API.
+var defaultRetryPolicies = map[string]RetryPolicy{
+	RetryModePrompt: {
+		maxAttempts: 2,
+		statusCodes: map[int]bool{502: true, 503: true, 504: true},
+		maxDelay:    500 * time.Millisecond,
+	},
+	RetryModeWatch: {
+		maxAttempts: 10,
+		statusCodes: map[int]bool{500: true, 502: true, 503: true, 504: true},
+		maxDelay:    5 * time.Minute,
+	},
+}
+
+// LoadRetryPolicy reads github-commit-status.<mode>RetryMaxAttempts,
+// <mode>RetryStatusCodes (comma-separated) and <mode>RetryMaxDelay.
+func LoadRetryPolicy(mode string) RetryPolicy {
+	policy := defaultRetryPolicies[mode]
+
+	policy.maxAttempts = ConfigInt(mode+"RetryMaxAttempts", policy.maxAttempts)
+	policy.maxDelay = ConfigDuration(mode+"RetryMaxDelay", policy.maxDelay)
+
+	if codes := ConfigValue(mode + "RetryStatusCodes"); codes != "" {
+		policy.statusCodes = map[int]bool{}
+		for _, c := range strings.Split(codes, ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(c)); err == nil {
+				policy.statusCodes[n] = true
+			}
+		}
+	}
+
+	return policy
+}
+
+// retryAfter returns how long a response asks to wait before trying
+// again, in seconds or as an HTTP date, as GitHub does when a secondary
+// rate limit is hit.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+
+	return 0, false
+}
+
+// retryTransport retries failed requests, waiting exponentially longer
+// each time with jitter so that clients failing together do not retry
+// together, or as long as Retry-After says.
+type retryTransport struct {
+	base   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var waited time.Duration
+	backoff := initialRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+
+		// Half the backoff, and up to as much again at random
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		after, hasRetryAfter := retryAfter(resp)
+		if hasRetryAfter {
+			delay = after
+		}
+
+		retryable := err != nil || t.policy.statusCodes[resp.StatusCode] ||
+			hasRetryAfter && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests)
+		if req.Body != nil && req.GetBody == nil {
+			retryable = false
+		}
+		if !retryable || attempt >= t.policy.maxAttempts || waited+delay > t.policy.maxDelay {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		waited += delay
+		backoff *= 2
+	}
+}
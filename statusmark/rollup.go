@@ -0,0 +1,52 @@
+package statusmark
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+// runRollupScript computes the overall state with the Starlark script at
+// path, which must define rollup(contexts) returning a state string. Each
+// context is a dict with context, state, description, target_url and
+// creator keys; the globals branch and revision describe the target.
+func runRollupScript(path string, contexts []ContextStatus, branch, rev string) (string, error) {
+	thread := &starlark.Thread{Name: "rollup"}
+	predeclared := starlark.StringDict{
+		"branch":   starlark.String(branch),
+		"revision": starlark.String(rev),
+	}
+
+	globals, err := starlark.ExecFile(thread, path, nil, predeclared)
+	if err != nil {
+		return "", err
+	}
+
+	fn, ok := globals["rollup"].(starlark.Callable)
+	if !ok {
+		return "", fmt.Errorf("%s: rollup(contexts) is not defined", path)
+	}
+
+	list := make([]starlark.Value, len(contexts))
+	for i, c := range contexts {
+		d := starlark.NewDict(5)
+		d.SetKey(starlark.String("context"), starlark.String(c.Context))
+		d.SetKey(starlark.String("state"), starlark.String(c.State))
+		d.SetKey(starlark.String("description"), starlark.String(c.Description))
+		d.SetKey(starlark.String("target_url"), starlark.String(c.TargetURL))
+		d.SetKey(starlark.String("creator"), starlark.String(c.Creator))
+		list[i] = d
+	}
+
+	result, err := starlark.Call(thread, fn, starlark.Tuple{starlark.NewList(list)}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	state, ok := starlark.AsString(result)
+	if !ok {
+		return "", fmt.Errorf("%s: rollup() returned %s, not a string", path, result.Type())
+	}
+
+	return state, nil
+}
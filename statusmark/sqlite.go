@@ -0,0 +1,161 @@
+//go:build sqlite
+
+package statusmark
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	// Needs cgo; without it, opening the database fails
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS entries (
+	repo      TEXT NOT NULL,
+	key       TEXT NOT NULL,
+	entry     TEXT NOT NULL,
+	last_used INTEGER NOT NULL,
+	PRIMARY KEY (repo, key)
+);
+CREATE INDEX IF NOT EXISTS entries_last_used ON entries (last_used);
+CREATE TABLE IF NOT EXISTS repos (
+	repo  TEXT PRIMARY KEY,
+	state TEXT NOT NULL
+);
+`
+
+// sqliteStore keeps the caches of all repositories in one SQLite database,
+// an entry per row, so that only the entries of one repository are read and
+// only those changed are written, and many processes (as for batches or
+// the daemon) can share it.
+type sqliteStore struct {
+	path string
+	repo string
+	// saved are the entries as last read or written, in JSON by key
+	saved map[string]string
+}
+
+func newSQLiteStore(path, repo string) (*sqliteStore, error) {
+	return &sqliteStore{path: path, repo: repo}, nil
+}
+
+func (s *sqliteStore) open() (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0777); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", s.path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (s *sqliteStore) restore(state *Cache) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var blob string
+	err = db.QueryRow("SELECT state FROM repos WHERE repo = ?", s.repo).Scan(&blob)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if blob != "" {
+		// A broken row is started over like a broken file
+		json.Unmarshal([]byte(blob), state)
+	}
+
+	rows, err := db.Query("SELECT key, entry FROM entries WHERE repo = ?", s.repo)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	s.saved = map[string]string{}
+	if state.Revisions == nil {
+		state.Revisions = map[string]Entry{}
+	}
+	for rows.Next() {
+		var key, buf string
+		if err := rows.Scan(&key, &buf); err != nil {
+			return err
+		}
+
+		var entry Entry
+		if err := json.Unmarshal([]byte(buf), &entry); err != nil {
+			continue
+		}
+		state.Revisions[key] = entry
+		s.saved[key] = buf
+	}
+
+	return rows.Err()
+}
+
+func (s *sqliteStore) save(state *Cache) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Everything but the entries goes in one row
+	rest := *state
+	rest.Revisions = nil
+	rest.Version = cacheVersion
+	blob, err := json.Marshal(rest)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO repos (repo, state) VALUES (?, ?)", s.repo, string(blob)); err != nil {
+		return err
+	}
+
+	saved := map[string]string{}
+	for key, entry := range state.Revisions {
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		saved[key] = string(buf)
+
+		if s.saved[key] == string(buf) {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR REPLACE INTO entries (repo, key, entry, last_used) VALUES (?, ?, ?, ?)", s.repo, key, string(buf), entry.lastUsed()); err != nil {
+			return err
+		}
+	}
+	for key := range s.saved {
+		if _, ok := saved[key]; ok {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM entries WHERE repo = ? AND key = ?", s.repo, key); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.saved = saved
+
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !sqlite
+
+package statusmark
+
+import "errors"
+
+// sqliteStore stands for the SQLite cache backend in builds without the
+// sqlite tag, which leave out its driver, as that needs cgo.
+type sqliteStore struct {
+	path string
+}
+
+func newSQLiteStore(path, repo string) (*sqliteStore, error) {
+	return nil, errors.New("Cache backend sqlite is not built in; build with -tags sqlite")
+}
+
+func (s *sqliteStore) restore(state *Cache) error {
+	return nil
+}
+
+func (s *sqliteStore) save(state *Cache) error {
+	return nil
+}
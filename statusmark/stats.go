@@ -0,0 +1,70 @@
+package statusmark
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type CacheStats struct {
+	Hits         int
+	Misses       int
+	APICalls     int64
+	Fetches      int
+	FetchTime    time.Duration
+	MaxFetchTime time.Duration
+}
+
+func (stats *CacheStats) recordFetch(d time.Duration) {
+	stats.Fetches++
+	stats.FetchTime += d
+	if d > stats.MaxFetchTime {
+		stats.MaxFetchTime = d
+	}
+}
+
+// RecordHit counts a lookup answered from the cache. The count is added to
+// Stats.Hits when state is saved, so that reading the cache again under
+// its lock does not lose it.
+func (state *Cache) RecordHit() {
+	state.hits++
+}
+
+func (stats CacheStats) Print() {
+	var hitRate float64
+	if total := stats.Hits + stats.Misses; total > 0 {
+		hitRate = float64(stats.Hits) / float64(total) * 100
+	}
+
+	var avgFetchTime time.Duration
+	if stats.Fetches > 0 {
+		avgFetchTime = stats.FetchTime / time.Duration(stats.Fetches)
+	}
+
+	fmt.Printf("hits:          %d\n", stats.Hits)
+	fmt.Printf("misses:        %d\n", stats.Misses)
+	fmt.Printf("hit rate:      %.1f%%\n", hitRate)
+	fmt.Printf("api calls:     %d\n", stats.APICalls)
+	fmt.Printf("fetches:       %d\n", stats.Fetches)
+	fmt.Printf("avg latency:   %s\n", avgFetchTime.Round(time.Millisecond))
+	fmt.Printf("max latency:   %s\n", stats.MaxFetchTime.Round(time.Millisecond))
+}
+
+// countingTransport counts and records every request actually sent to the
+// API, including retries. Clients are shared between goroutines, so the
+// count is updated atomically.
+type countingTransport struct {
+	base  http.RoundTripper
+	count *int64
+	trail *Explanation
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.count != nil {
+		atomic.AddInt64(t.count, 1)
+	}
+	t.trail.Add("request: %s %s", req.Method, req.URL)
+
+	return t.base.RoundTrip(req)
+}
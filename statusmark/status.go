@@ -0,0 +1,214 @@
+package statusmark
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	StatusUnknown = ""
+	StatusFailure = "failure"
+	StatusPending = "pending"
+	StatusSuccess = "success"
+	StatusWarning = "warning"
+	// The CI could not run the checks at all, rather than the checks failing
+	StatusError = "error"
+
+	StatusActionRequired = "action_required"
+	StatusQueued         = "queued"
+	StatusInProgress     = "in_progress"
+	StatusLocal          = "local"
+	StatusNotFound       = "not_found"
+)
+
+// ContextStatus is the latest status reported for one context.
+type ContextStatus struct {
+	Context     string
+	State       string
+	Description string
+	TargetURL   string
+	Creator     string
+	UpdatedAt   time.Time
+}
+
+// LatestContexts picks the most recent status of every context; the API
+// lists statuses newest first.
+func LatestContexts(statuses []github.RepoStatus) []ContextStatus {
+	seen := map[string]bool{}
+	contexts := []ContextStatus{}
+
+	for _, s := range statuses {
+		c := ContextStatus{
+			Context:     stringValue(s.Context),
+			State:       stringValue(s.State),
+			Description: stringValue(s.Description),
+			TargetURL:   stringValue(s.TargetURL),
+		}
+		if s.Creator != nil {
+			c.Creator = stringValue(s.Creator.Login)
+		}
+		if s.UpdatedAt != nil {
+			c.UpdatedAt = *s.UpdatedAt
+		}
+
+		if seen[c.Context] {
+			continue
+		}
+		seen[c.Context] = true
+
+		contexts = append(contexts, c)
+	}
+
+	return contexts
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// WarningContextPatterns returns the space-separated globs in
+// github-commit-status.warningContexts. Failures of matching contexts only
+// produce a warning instead of failing the whole commit.
+func WarningContextPatterns() []string {
+	return strings.Fields(ConfigValue("warningContexts"))
+}
+
+// applyContextSettings keeps only contexts matching the space-separated
+// globs in github-commit-status.includedContexts, if set, drops those
+// matching ignoredContexts and adds a pending placeholder for each context
+// named in requiredContexts that has not reported yet.
+func applyContextSettings(contexts []ContextStatus) ([]ContextStatus, bool) {
+	included := strings.Fields(ConfigValue("includedContexts"))
+	ignored := strings.Fields(ConfigValue("ignoredContexts"))
+	required := strings.Fields(ConfigValue("requiredContexts"))
+	if len(included) == 0 && len(ignored) == 0 && len(required) == 0 {
+		return contexts, false
+	}
+
+	return addMissingContexts(filterContexts(contexts, included, ignored), required), true
+}
+
+// addMissingContexts adds a pending placeholder to contexts for each of
+// required that has not reported yet.
+func addMissingContexts(contexts []ContextStatus, required []string) []ContextStatus {
+	seen := map[string]bool{}
+	for _, c := range contexts {
+		seen[c.Context] = true
+	}
+
+	for _, name := range required {
+		if !seen[name] {
+			contexts = append(contexts, ContextStatus{
+				Context:     name,
+				State:       StatusPending,
+				Description: "Required context has not reported yet",
+			})
+		}
+	}
+
+	return contexts
+}
+
+// filterContexts returns the contexts matching any of the globs in include,
+// or all if it is empty, and none of those in exclude.
+func filterContexts(contexts []ContextStatus, include, exclude []string) []ContextStatus {
+	result := []ContextStatus{}
+	for _, c := range contexts {
+		if len(include) > 0 && !MatchContext(c.Context, include) {
+			continue
+		}
+		if MatchContext(c.Context, exclude) {
+			continue
+		}
+		result = append(result, c)
+	}
+
+	return result
+}
+
+func MatchContext(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func IsFailing(state string) bool {
+	return state == StatusFailure || state == StatusError
+}
+
+func IsPending(state string) bool {
+	return state == StatusPending || state == StatusQueued || state == StatusInProgress
+}
+
+// Progress returns how many contexts of entry have completed, out of all.
+func (entry Entry) Progress() (completed, total int) {
+	for _, c := range entry.Contexts {
+		if !IsPending(c.State) {
+			completed++
+		}
+	}
+
+	return completed, len(entry.Contexts)
+}
+
+// rollupContexts combines the latest status of every context into one. Any
+// failure fails the commit, then any error, then a context waiting for
+// action wins. Otherwise anything pending keeps it pending, and queued if
+// nothing is running yet. A failing warning-only context turns an
+// otherwise successful commit into a warning.
+func rollupContexts(contexts []ContextStatus, warningPatterns []string) string {
+	if len(contexts) == 0 {
+		return StatusUnknown
+	}
+
+	var failing, erroring, actionRequired, inProgress, pending, queued, warning bool
+	for _, c := range contexts {
+		switch {
+		case IsFailing(c.State) && MatchContext(c.Context, warningPatterns):
+			warning = true
+		case c.State == StatusError:
+			erroring = true
+		case IsFailing(c.State):
+			failing = true
+		case c.State == StatusWarning:
+			// Failures allowed by the CI itself, as on GitLab
+			warning = true
+		case c.State == StatusActionRequired:
+			actionRequired = true
+		case c.State == StatusInProgress:
+			inProgress = true
+		case c.State == StatusPending:
+			pending = true
+		case c.State == StatusQueued:
+			queued = true
+		}
+	}
+
+	switch {
+	case failing:
+		return StatusFailure
+	case erroring:
+		return StatusError
+	case actionRequired:
+		return StatusActionRequired
+	case inProgress:
+		return StatusInProgress
+	case pending:
+		return StatusPending
+	case queued:
+		return StatusQueued
+	case warning:
+		return StatusWarning
+	default:
+		return StatusSuccess
+	}
+}
@@ -0,0 +1,140 @@
+package statusmark
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// allStatuses are the statuses marks can be configured for.
+var allStatuses = []string{
+	StatusUnknown, StatusFailure, StatusPending, StatusSuccess, StatusWarning, StatusError,
+	StatusActionRequired, StatusQueued, StatusInProgress, StatusLocal, StatusNotFound,
+}
+
+// StatusName returns how status is called in settings and output, where
+// StatusUnknown is "unknown".
+func StatusName(status string) string {
+	if status == StatusUnknown {
+		return "unknown"
+	}
+	return status
+}
+
+// StatusSetting overrides how a status is shown and cached, from
+// <status>.mark, <status>.color and <status>.cacheFor, e.g.
+// github-commit-status.failure.mark. Empty fields are not set.
+type StatusSetting struct {
+	Mark     string
+	Color    string
+	CacheFor string
+}
+
+var statusSettings map[string]StatusSetting
+
+// StatusSettings returns the settings of every status that has any, keyed
+// by status. They are read from each source at once rather than one key at
+// a time, which would take dozens of runs of git.
+func StatusSettings() map[string]StatusSetting {
+	if statusSettings != nil {
+		return statusSettings
+	}
+
+	// From the lowest precedence up
+	layers := []map[string]string{repoConfig, loadUserConfig(), gitConfigSection("github-commit-status.")}
+	if Profile != "" {
+		layers = append(layers, gitConfigSection("github-commit-status-profile."+Profile+"."))
+	}
+	env := map[string]string{}
+	for _, status := range allStatuses {
+		for _, key := range []string{"mark", "color", "cacheFor"} {
+			key = StatusName(status) + "." + key
+			if v := os.Getenv(envName(key)); v != "" {
+				env[key] = v
+			}
+		}
+	}
+	layers = append(layers, env)
+
+	statusSettings = map[string]StatusSetting{}
+	for _, status := range allStatuses {
+		var s StatusSetting
+		for _, layer := range layers {
+			for key, v := range layer {
+				switch strings.ToLower(key) {
+				case StatusName(status) + ".mark":
+					s.Mark = v
+				case StatusName(status) + ".color":
+					s.Color = v
+				case StatusName(status) + ".cachefor":
+					s.CacheFor = v
+				}
+			}
+		}
+		if s != (StatusSetting{}) {
+			statusSettings[status] = s
+		}
+	}
+
+	return statusSettings
+}
+
+// gitConfigSection returns the settings of git config under prefix, keyed
+// by the rest of their names. git lowercases all but subsections.
+func gitConfigSection(prefix string) map[string]string {
+	values := map[string]string{}
+	for key, value := range gitConfigValues() {
+		if strings.HasPrefix(key, prefix) {
+			values[strings.TrimPrefix(key, prefix)] = value
+		}
+	}
+
+	return values
+}
+
+// cacheForFlags are the -ttl flags by status.
+var cacheForFlags = map[string]string{}
+
+// SetCacheFor makes entries of the status called name stay fresh for ttl,
+// a duration or "forever", over <status>.cacheFor.
+func SetCacheFor(name, ttl string) error {
+	for _, status := range allStatuses {
+		if StatusName(status) != name {
+			continue
+		}
+
+		if _, err := time.ParseDuration(ttl); err != nil && ttl != "forever" {
+			return fmt.Errorf("not a duration or \"forever\": %s", ttl)
+		}
+		cacheForFlags[status] = ttl
+		return nil
+	}
+
+	return fmt.Errorf("no such status: %s", name)
+}
+
+// statusCacheFor returns how long an entry of status stays fresh, from -ttl
+// or <status>.cacheFor if set: a duration, or "forever".
+func statusCacheFor(status string) time.Duration {
+	ttl, ok := cacheFor[status]
+	if !ok {
+		ttl = cacheFor[StatusUnknown]
+	}
+
+	v, ok := cacheForFlags[status]
+	if !ok {
+		v = StatusSettings()[status].CacheFor
+	}
+	switch v {
+	case "":
+	case "forever":
+		ttl = forever
+	default:
+		if d, err := time.ParseDuration(v); err == nil {
+			ttl = d
+		}
+	}
+
+	return ttl
+}
@@ -0,0 +1,100 @@
+// Package statusmark finds the commit status of revisions of a git repository
+// on GitHub, caching it in the repository so that it is cheap enough to show
+// in a shell prompt.
+package statusmark
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+)
+
+// Options are the settings of a Client not read from git config.
+type Options struct {
+	// DryRun prints requests instead of sending them
+	DryRun bool
+	// Upstream looks up statuses on the parent of forked repositories
+	Upstream bool
+	// PullRequest prefers the status of the pull request containing the
+	// revision
+	PullRequest bool
+	// Include and Exclude select the contexts to roll up
+	Include, Exclude []string
+	// TokenSource, if set, authenticates in place of the configured token
+	TokenSource oauth2.TokenSource
+}
+
+// Client looks up the status of revisions of the repository in the current
+// directory.
+type Client struct {
+	Repo   Repository
+	Cache  *Cache
+	lookup *Lookup
+}
+
+// NewClient opens the repository in the current directory and restores its
+// cache.
+func NewClient(opts Options) (*Client, error) {
+	repo := OpenRepository()
+	toplevel, err := repo.Toplevel()
+	if err != nil {
+		return nil, err
+	}
+	if err := LoadRepoConfig(toplevel); err != nil {
+		return nil, err
+	}
+
+	cache, err := NewCache(repo)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.Restore(); err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		Repo:  repo,
+		Cache: cache,
+		lookup: &Lookup{
+			Repo:        repo,
+			Cache:       cache,
+			DryRun:      opts.DryRun,
+			Upstream:    opts.Upstream || ConfigBool("upstream"),
+			PullRequest: opts.PullRequest || ConfigBool("associatedPullRequest"),
+			Include:     opts.Include,
+			Exclude:     opts.Exclude,
+			TokenSource: opts.TokenSource,
+		},
+	}, nil
+}
+
+// Resolve returns the status of rev, from the cache while it is fresh and
+// from the API otherwise. What is fetched is saved under the lock of the
+// cache at once; call Save to keep the hits as well.
+func (c *Client) Resolve(rev string) (Status, error) {
+	_, sha, err := c.Repo.Resolve(rev)
+	if err != nil {
+		return Status{}, err
+	}
+
+	entry, fresh := c.lookup.Cached(sha)
+	if fresh {
+		c.Cache.RecordHit()
+	} else {
+		err := c.Cache.Update(context.Background(), func() (err error) {
+			c.Cache.Stats.Misses++
+			entry, _, _, err = c.lookup.Fetch(sha)
+			return err
+		})
+		if err != nil {
+			return Status{}, err
+		}
+	}
+
+	return NewStatus(c.lookup.Selected(entry), sha, fresh), nil
+}
+
+// Save writes the cache back under its lock.
+func (c *Client) Save() error {
+	return c.Cache.Update(context.Background(), nil)
+}
@@ -0,0 +1,67 @@
+package statusmark
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenSource returns the source of API tokens for remoteURL and a
+// description of where they come from, or nil if there is none: the
+// installation tokens of a GitHub App if one is set (on GitHub only), a
+// static token found by RetrieveAPIToken, or else the output of
+// github-commit-status.tokenCommand, run again whenever it expires.
+func TokenSource(remoteURL *url.URL) (oauth2.TokenSource, string) {
+	if ProviderOf(remoteURL) == ProviderGitHub {
+		if s := appTokenSource(remoteURL); s != nil {
+			return s, "GitHub App " + configURLValue("appID", remoteURL)
+		}
+	}
+
+	if token, source := RetrieveAPIToken(remoteURL); token != "" {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), source
+	}
+
+	if command := configURLValue("tokenCommand", remoteURL); command != "" {
+		return oauth2.ReuseTokenSource(nil, commandTokenSource{command: command, url: remoteURL}), "tokenCommand"
+	}
+
+	return nil, ""
+}
+
+// commandTokenSource runs a shell command printing a token on the first
+// line, and optionally when it expires in RFC 3339 on the second. The URL
+// of the repository is passed in GITHUB_COMMIT_STATUS_MARK_URL.
+type commandTokenSource struct {
+	command string
+	url     *url.URL
+}
+
+func (s commandTokenSource) Token() (*oauth2.Token, error) {
+	cmd := exec.Command("sh", "-c", s.command)
+	cmd.Env = append(cmd.Environ(), "GITHUB_COMMIT_STATUS_MARK_URL="+s.url.String())
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("tokenCommand: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	token := &oauth2.Token{AccessToken: strings.TrimSpace(lines[0])}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("tokenCommand: printed no token")
+	}
+	if len(lines) > 1 {
+		expiry, err := time.Parse(time.RFC3339, strings.TrimSpace(lines[1]))
+		if err != nil {
+			return nil, fmt.Errorf("tokenCommand: %s", err)
+		}
+		token.Expiry = expiry
+	}
+
+	return token, nil
+}
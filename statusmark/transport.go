@@ -0,0 +1,85 @@
+package statusmark
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// CAFile and Insecure are -ca-file and -insecure, taking precedence over
+// any setting.
+var (
+	CAFile   string
+	Insecure bool
+)
+
+// newHTTPTransport builds the transport for talking to the API host of
+// remoteURL. Certificates are verified unless -insecure,
+// github-commit-status.<url>.insecure or git's http.sslVerify says not to,
+// against the system's CAs and any from -ca-file,
+// github-commit-status.<url>.caFile or git's http.sslCAInfo. git's
+// settings (including their http.<url>.* variants and GIT_SSL_*
+// environment variables) and http.proxy are honored so setups already
+// working for git need nothing more.
+func newHTTPTransport(remoteURL *url.URL) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{}
+
+	verify := true
+	if v := configURLValue("insecure", remoteURL); v != "" {
+		insecure, _ := strconv.ParseBool(v)
+		verify = !insecure
+	} else if v := GitConfig("--bool", "--get-urlmatch", "http.sslVerify", remoteURL.String()); v != "" {
+		verify, _ = strconv.ParseBool(v)
+	}
+	if os.Getenv("GIT_SSL_NO_VERIFY") != "" || Insecure {
+		verify = false
+	}
+
+	caFile := CAFile
+	if caFile == "" {
+		caFile = configURLValue("caFile", remoteURL)
+	}
+	if caFile == "" {
+		caFile = os.Getenv("GIT_SSL_CAINFO")
+	}
+	if caFile == "" {
+		caFile = GitConfig("--path", "--get-urlmatch", "http.sslCAInfo", remoteURL.String())
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+
+	t.TLSClientConfig.InsecureSkipVerify = !verify
+
+	if proxy := GitConfig("--get-urlmatch", "http.proxy", remoteURL.String()); proxy != "" {
+		// git accepts bare host:port
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			proxyURL, err = url.Parse("http://" + proxy)
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,81 @@
+package statusmark
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// isSettled reports whether status will not change by itself. Unknown and
+// not found are waited out for up to unknownTimeout since the watch started,
+// as CI may not have reported anything yet, but may well never do.
+func isSettled(status string, waited, unknownTimeout time.Duration) bool {
+	if status == StatusUnknown || status == StatusNotFound {
+		return waited >= unknownTimeout
+	}
+
+	return !IsPending(status)
+}
+
+// Watch fetches the status of rev every interval until it settles, calling
+// changed with the first entry and every entry whose status or progress
+// differs from the previous one. What is fetched is saved in the cache
+// every time. Unknown and not found settle after
+// github-commit-status.watchUnknownTimeout, 1m by default.
+//
+// While checks are queued, which may take long on busy runners, the
+// interval doubles up to github-commit-status.watchMaxInterval, 8 times
+// interval by default, and is back to interval once they start. Every wait
+// is off by up to a tenth at random, so that watches started together do
+// not poll together.
+func (l *Lookup) Watch(rev string, interval time.Duration, changed func(Entry)) (Entry, error) {
+	unknownTimeout := ConfigDuration("watchUnknownTimeout", time.Minute)
+	maxInterval := ConfigDuration("watchMaxInterval", 8*interval)
+	start := time.Now()
+	wait := interval
+
+	ctx := l.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var last Entry
+	for i := 0; ; i++ {
+		var entry Entry
+		err := l.Cache.Update(ctx, func() (err error) {
+			entry, _, _, err = l.Fetch(rev)
+			return err
+		})
+		if err != nil {
+			return last, err
+		}
+		entry = l.Selected(entry)
+		completed, total := entry.Progress()
+		lastCompleted, lastTotal := last.Progress()
+		if i == 0 || entry.Status != last.Status || completed != lastCompleted || total != lastTotal {
+			changed(entry)
+		}
+		last = entry
+
+		if isSettled(entry.Status, time.Since(start), unknownTimeout) {
+			return entry, nil
+		}
+
+		if entry.Status != StatusQueued {
+			wait = interval
+		} else if wait *= 2; wait > maxInterval {
+			wait = maxInterval
+		}
+		time.Sleep(jitter(wait))
+	}
+}
+
+// jitter returns d off by up to a tenth either way.
+func jitter(d time.Duration) time.Duration {
+	spread := int64(d / 10)
+	if spread <= 0 {
+		return d
+	}
+
+	return d - time.Duration(spread) + time.Duration(rand.Int63n(2*spread+1))
+}
@@ -0,0 +1,19 @@
+//go:build !otel
+
+package main
+
+import "context"
+
+// Spans are only recorded by builds with the otel tag; see trace_otel.go.
+
+func initTracing(daemon bool) error {
+	return nil
+}
+
+func startSpan(ctx context.Context, name string, attrs ...string) (context.Context, func(error)) {
+	return ctx, func(error) {}
+}
+
+func tracingContext() context.Context {
+	return context.Background()
+}
@@ -0,0 +1,104 @@
+//go:build otel
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/motemen/github-commit-status-mark/statusmark"
+)
+
+var tracer = otel.Tracer("github.com/motemen/github-commit-status-mark")
+
+// initTracing exports spans over OTLP/HTTP to where the standard
+// OTEL_EXPORTER_OTLP_* variables say, if they are set at all, and traces
+// the API requests sent. The daemon exports in batches; other runs export
+// every span as it ends, as they may exit at any point.
+func initTracing(daemon bool) error {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return err
+	}
+
+	export := sdktrace.WithSyncer(exporter)
+	if daemon {
+		export = sdktrace.WithBatcher(exporter)
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(export))
+
+	statusmark.WrapTransport = func(base http.RoundTripper) http.RoundTripper {
+		return tracingTransport{base: base}
+	}
+
+	return nil
+}
+
+// startSpan starts a span called name under the one in ctx, with attrs as
+// pairs of keys and values, and returns the function ending it with the
+// error, if any, of what it covered.
+func startSpan(ctx context.Context, name string, attrs ...string) (context.Context, func(error)) {
+	var kvs []attribute.KeyValue
+	for i := 0; i+1 < len(attrs); i += 2 {
+		kvs = append(kvs, attribute.String(attrs[i], attrs[i+1]))
+	}
+
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(kvs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// tracingContext returns the context of the span TRACEPARENT names, so
+// that a run from a traced script or job is traced under it.
+func tracingContext() context.Context {
+	carrier := propagation.MapCarrier{"traceparent": os.Getenv("TRACEPARENT")}
+	return propagation.TraceContext{}.Extract(context.Background(), carrier)
+}
+
+// tracingTransport records a span for every API request.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+		),
+	)
+	defer span.End()
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+
+	return resp, nil
+}
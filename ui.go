@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/motemen/github-commit-status-mark/statusmark"
+	"golang.org/x/term"
+)
+
+// uiItem is a line of the ui: a check run, or a legacy status which can only
+// be opened.
+type uiItem struct {
+	name  string
+	state string
+	url   string
+	run   *statusmark.CheckRun
+}
+
+type commitUI struct {
+	client   *github.Client
+	remote   statusmark.Remote
+	rev      string
+	items    []uiItem
+	selected int
+	message  string
+}
+
+func (u *commitUI) refresh() {
+	var items []uiItem
+
+	runs, err := statusmark.ListCheckRuns(u.client, u.remote, u.rev)
+	if err != nil {
+		u.message = fmt.Sprintf("Error while fetching check runs: %s", err)
+	}
+	conclusions := statusmark.ConclusionStates()
+	for i := range runs {
+		run := runs[i]
+		items = append(items, uiItem{
+			name:  run.Name,
+			state: statusmark.CheckRunState(run.Status, run.Conclusion, conclusions),
+			url:   run.HTMLURL,
+			run:   &run,
+		})
+	}
+
+	statuses, _, err := u.client.Repositories.ListStatuses(u.remote.Owner, u.remote.Name, u.rev, nil)
+	if err != nil {
+		u.message = fmt.Sprintf("Error while fetching statuses: %s", err)
+	}
+	for _, c := range statusmark.LatestContexts(statuses) {
+		items = append(items, uiItem{name: c.Context, state: c.State, url: c.TargetURL})
+	}
+
+	u.items = items
+	if u.selected >= len(u.items) {
+		u.selected = len(u.items) - 1
+	}
+	if u.selected < 0 {
+		u.selected = 0
+	}
+}
+
+// draw redraws the whole screen; the terminal is in raw mode, so lines end
+// with \r\n.
+func (u *commitUI) draw() {
+	var b strings.Builder
+
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "%s/%s %s  (updated %s)\r\n\r\n", u.remote.Owner, u.remote.Name, shortSHA(u.rev), time.Now().Format("15:04:05"))
+
+	for i, item := range u.items {
+		cursor := "  "
+		if i == u.selected {
+			cursor = "> "
+		}
+
+		conf, ok := statusConfiguration[item.state]
+		if !ok {
+			conf = statusConfiguration[statusmark.StatusUnknown]
+		}
+		mark := conf.mark
+		if terminalColor() {
+			mark = colorize(mark, conf.color, escapeNone)
+		}
+
+		fmt.Fprintf(&b, "%s%s %s\r\n", cursor, mark, item.name)
+	}
+	if len(u.items) == 0 {
+		b.WriteString("  no checks reported\r\n")
+	}
+
+	fmt.Fprintf(&b, "\r\n%s\r\n", u.message)
+	b.WriteString("j/k: move  o: open  a: annotations  r: re-run  c: cancel  q: quit")
+
+	fmt.Print(b.String())
+}
+
+func (u *commitUI) current() *uiItem {
+	if len(u.items) == 0 {
+		return nil
+	}
+	return &u.items[u.selected]
+}
+
+// post sends a request without body, for the actions on check runs.
+func (u *commitUI) post(path string) error {
+	req, err := u.client.NewRequest("POST", fmt.Sprintf("repos/%s/%s/%s", u.remote.Owner, u.remote.Name, path), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.client.Do(req, nil)
+	return err
+}
+
+// rerun re-runs a check run: a job of GitHub Actions, or by asking the app
+// that created it.
+func (u *commitUI) rerun(run *statusmark.CheckRun) error {
+	if run.App.Slug == "github-actions" {
+		return u.post(fmt.Sprintf("actions/jobs/%d/rerun", run.ID))
+	}
+	return u.post(fmt.Sprintf("check-runs/%d/rerequest", run.ID))
+}
+
+// cancel cancels the workflow run a check run of GitHub Actions belongs to,
+// as single jobs cannot be cancelled.
+func (u *commitUI) cancel(run *statusmark.CheckRun) error {
+	if run.App.Slug != "github-actions" {
+		return fmt.Errorf("only GitHub Actions runs can be cancelled")
+	}
+
+	req, err := u.client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/actions/jobs/%d", u.remote.Owner, u.remote.Name, run.ID), nil)
+	if err != nil {
+		return err
+	}
+
+	var job struct {
+		RunID int64 `json:"run_id"`
+	}
+	if _, err := u.client.Do(req, &job); err != nil {
+		return err
+	}
+
+	return u.post(fmt.Sprintf("actions/runs/%d/cancel", job.RunID))
+}
+
+// showAnnotations lists the annotations of run until a key is pressed.
+func (u *commitUI) showAnnotations(run *statusmark.CheckRun, keys <-chan string) {
+	annotations, err := listAnnotations(u.client, u.remote, run.ID)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "%s\r\n\r\n", run.Name)
+	if err != nil {
+		fmt.Fprintf(&b, "Error while fetching annotations: %s\r\n", err)
+	}
+	for _, a := range annotations {
+		fmt.Fprintf(&b, "%s\r\n", quickfixLine(*run, a, a.Path))
+	}
+	if err == nil && len(annotations) == 0 {
+		b.WriteString("no annotations\r\n")
+	}
+	b.WriteString("\r\npress any key to go back")
+	fmt.Print(b.String())
+
+	<-keys
+}
+
+// openBrowser opens url with the program the desktop uses for it.
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
+// readKeys sends each key pressed, arrow keys as "up" and "down".
+func readKeys(keys chan<- string) {
+	buf := make([]byte, 8)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+
+		switch s := string(buf[:n]); s {
+		case "\x1b[A":
+			keys <- "up"
+		case "\x1b[B":
+			keys <- "down"
+		default:
+			keys <- s
+		}
+	}
+}
+
+// runUI shows the checks of the target revision, refreshing them
+// periodically, and acts on the selected one.
+func runUI(repo statusmark.Repository, args []string) {
+	flags := flag.NewFlagSet("ui", flag.ExitOnError)
+	interval := flags.Duration("interval", statusmark.ConfigDuration("uiInterval", 10*time.Second), "Refresh every `duration`")
+	flags.Parse(args)
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		die("ui needs a terminal")
+	}
+
+	toplevel, rev, err := repo.Resolve(targetRevision(flags.Args()))
+	dieIf(err)
+	dieIf(statusmark.LoadRepoConfig(toplevel))
+	dieIf(useStatusSettings(statusmark.StatusSettings()))
+
+	state, err := statusmark.NewCache(repo)
+	dieIf(err)
+	dieIf(state.Restore())
+
+	remote, err := statusmark.ParseRemote(repo, statusmark.ConfiguredRemotes(repo)[0])
+	dieIf(err)
+	requireGitHub(remote, "ui")
+	// Counted apart, to be added to the cache read again under its lock
+	var apiCalls int64
+	client := statusmark.NewAPIClient(remote.URL, statusmark.ClientOptions{
+		RetryPolicy: statusmark.LoadRetryPolicy(statusmark.RetryModeWatch),
+		APICalls:    &apiCalls,
+	})
+	dieIf(statusmark.RequireFeature(state.HostInfo(client, remote), remote, statusmark.FeatureChecks))
+
+	u := &commitUI{client: client, remote: remote, rev: rev}
+	u.refresh()
+
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	dieIf(err)
+	defer func() {
+		term.Restore(int(os.Stdin.Fd()), oldState)
+		fmt.Print("\r\n")
+		dieIf(state.Update(context.Background(), func() error {
+			state.Stats.APICalls += apiCalls
+			return nil
+		}))
+	}()
+
+	keys := make(chan string)
+	go readKeys(keys)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		u.draw()
+
+		select {
+		case <-ticker.C:
+			u.refresh()
+			continue
+
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			u.message = ""
+
+			item := u.current()
+			switch key {
+			case "q", "\x03":
+				return
+			case "j", "down":
+				if u.selected < len(u.items)-1 {
+					u.selected++
+				}
+			case "k", "up":
+				if u.selected > 0 {
+					u.selected--
+				}
+			case "o":
+				if item == nil || item.url == "" {
+					u.message = "nothing to open"
+				} else if err := openBrowser(item.url); err != nil {
+					u.message = err.Error()
+				}
+			case "a":
+				if item == nil || item.run == nil {
+					u.message = "only check runs have annotations"
+				} else {
+					u.showAnnotations(item.run, keys)
+				}
+			case "r":
+				if item == nil || item.run == nil {
+					u.message = "only check runs can be re-run"
+				} else if err := u.rerun(item.run); err != nil {
+					u.message = err.Error()
+				} else {
+					u.message = fmt.Sprintf("re-running %s", item.name)
+					u.refresh()
+				}
+			case "c":
+				if item == nil || item.run == nil {
+					u.message = "only check runs can be cancelled"
+				} else if err := u.cancel(item.run); err != nil {
+					u.message = err.Error()
+				} else {
+					u.message = fmt.Sprintf("cancelling %s", item.name)
+					u.refresh()
+				}
+			}
+		}
+	}
+}